@@ -0,0 +1,99 @@
+package mdns
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultAnnouncements = 2
+	maxAnnouncements     = 8
+	announceInterval     = 1 * time.Second
+)
+
+// announcements returns how many unsolicited responses we send for a name
+func (c *Conn) announcements() int {
+	switch n := c.config.Announcements; {
+	case n < defaultAnnouncements:
+		return defaultAnnouncements
+	case n > maxAnnouncements:
+		return maxAnnouncements
+	default:
+		return n
+	}
+}
+
+// announce implements RFC 6762 section 8.3, we send unsolicited responses
+// with all our records for name, the first two one second apart and the
+// interval doubling after that. A new announcement for the same name
+// replaces the one in progress.
+func (c *Conn) announce(name string) {
+	key := strings.ToLower(name)
+
+	c.lock.Lock()
+	c.announcing[key]++
+	generation := c.announcing[key]
+	c.lock.Unlock()
+
+	interval := announceInterval
+	for i := 0; i < c.announcements(); i++ {
+		if i > 0 {
+			select {
+			case <-time.After(interval):
+				interval *= 2
+			case <-c.closed:
+				return
+			}
+		}
+
+		c.lock.Lock()
+		current := c.announcing[key] == generation
+		c.lock.Unlock()
+		// The records are being probed again or a newer announcement
+		// took over
+		if !current || c.config.isTentativeName(name) {
+			return
+		}
+
+		Log().Debug("Announcing records", zap.String("name", name))
+		shared := c.config.sharedRecordsFor(name)
+		for j := range c.ifaces {
+			records := c.config.recordsFor(name, interfaceIPv4(&c.ifaces[j]))
+			c.multicastRecords(&c.ifaces[j], append(records, shared...))
+		}
+	}
+}
+
+// announceShared announces the shared records added before the server
+// started, they are not probed so their names are not announced otherwise
+func (c *Conn) announceShared() {
+	for _, name := range c.config.sharedNames() {
+		go c.announce(name)
+	}
+}
+
+// goodbye implements RFC 6762 section 10.1, records are sent with a TTL
+// of zero so peers drop them from their caches right away. records
+// returns copies of the records to send given our address on an interface
+func (c *Conn) goodbye(records func(ip net.IP) []dns.RR) {
+	for i := range c.ifaces {
+		rrs := records(interfaceIPv4(&c.ifaces[i]))
+		for _, rr := range rrs {
+			rr.Header().Ttl = 0
+		}
+		c.multicastRecords(&c.ifaces[i], rrs)
+	}
+}
+
+// multicastRecords sends records as an unsolicited response on an interface
+func (c *Conn) multicastRecords(ifi *net.Interface, records []dns.RR) {
+	if len(records) == 0 {
+		return
+	}
+
+	c.sendAnswer(createAnswerMessage(&dns.Msg{}, &records, nil), ifi.Index)
+}
@@ -0,0 +1,268 @@
+package mdns
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// cacheFlushDelay is how long records flushed by a cache-flush record
+	// or a goodbye packet stay in the cache
+	cacheFlushDelay = 1 * time.Second
+
+	// maxCacheEntries caps the records kept from the network, when the
+	// cache is full the entries closest to expiry make room for new ones
+	maxCacheEntries = 4096
+
+	// maxCacheTTL caps the TTL of cached records, the 75 minutes RFC 6762
+	// section 10 recommends for records that do not name a host
+	maxCacheTTL = 4500
+)
+
+// CacheEntry is a record received from the network
+type CacheEntry struct {
+	RR dns.RR
+	// Src is the host that sent the record and IfIndex the interface it
+	// came in from
+	Src     net.Addr
+	IfIndex int
+
+	Received time.Time
+	Expires  time.Time
+
+	// flushed is set once a cache-flush record or a goodbye packet made
+	// the entry expire, it no longer answers queries
+	flushed bool
+}
+
+// Cache holds the records received from the network until their TTL
+// expires, answers and additional records alike
+type Cache struct {
+	lock      sync.Mutex
+	entries   map[string]*CacheEntry
+	lastPurge time.Time
+}
+
+func newCache() *Cache {
+	return &Cache{
+		entries:   make(map[string]*CacheEntry),
+		lastPurge: time.Now(),
+	}
+}
+
+// Cache returns the records received by the connection
+func (c *Conn) Cache() *Cache {
+	return c.cache
+}
+
+// add stores a record received in packet p, RFC 6762 section 10.2 "when a
+// host receives a resource record with the cache-flush bit set, ... any
+// records of the same name, rrtype, and rrclass ... received more than one
+// second ago are flushed from the cache by setting their TTL to one second"
+func (c *Cache) add(rr dns.RR, p packet) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPurge) > pruneInterval {
+		c.purge(now)
+	}
+	h := rr.Header()
+	if h.Class&rrclassCacheFlush != 0 {
+		for _, e := range c.entries {
+			eh := e.RR.Header()
+			if eh.Rrtype == h.Rrtype && eh.Class == h.Class&^rrclassCacheFlush &&
+				strings.EqualFold(eh.Name, h.Name) && now.Sub(e.Received) > cacheFlushDelay {
+				c.expireSoon(e, now)
+			}
+		}
+	}
+
+	key := recordKey(rr)
+	// Goodbye packets, "Queriers receiving a Multicast DNS response with a
+	// TTL of zero SHOULD NOT immediately delete the record from the cache,
+	// but instead record a TTL of 1"
+	if h.Ttl == 0 {
+		if e, ok := c.entries[key]; ok {
+			c.expireSoon(e, now)
+		}
+		return
+	}
+
+	ttl := h.Ttl
+	if ttl > maxCacheTTL {
+		ttl = maxCacheTTL
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCacheEntries {
+		c.evict(now)
+	}
+
+	rr = dns.Copy(rr)
+	rr.Header().Class &^= rrclassCacheFlush
+	rr.Header().Ttl = ttl
+	c.entries[key] = &CacheEntry{
+		RR:       rr,
+		Src:      p.src,
+		IfIndex:  p.ifIndex,
+		Received: now,
+		Expires:  now.Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// evict makes room for one entry, removing the expired entries or else
+// the one closest to expiry, must be called with the lock held
+func (c *Cache) evict(now time.Time) {
+	c.purge(now)
+	if len(c.entries) < maxCacheEntries {
+		return
+	}
+
+	var soonest string
+	for key, e := range c.entries {
+		if soonest == "" || e.Expires.Before(c.entries[soonest].Expires) {
+			soonest = key
+		}
+	}
+	delete(c.entries, soonest)
+}
+
+// expireSoon makes an entry expire one second from now
+func (c *Cache) expireSoon(e *CacheEntry, now time.Time) {
+	if e.Expires.Sub(now) > cacheFlushDelay {
+		e.Expires = now.Add(cacheFlushDelay)
+	}
+	e.flushed = true
+}
+
+// Lookup returns copies of the unexpired records for name and type with
+// their remaining TTL, dns.TypeANY returns the records of every type
+func (c *Cache) Lookup(name string, rrtype uint16) []dns.RR {
+	return entryRecords(c.lookup(name, rrtype, false))
+}
+
+// Entries returns copies of all the unexpired entries, their records
+// have the remaining TTL
+func (c *Cache) Entries() []CacheEntry {
+	return c.lookup("", dns.TypeANY, false)
+}
+
+// Len returns the number of unexpired records
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.purge(time.Now())
+	return len(c.entries)
+}
+
+// answers returns the records for name and type that answer a query,
+// the ones being flushed are left out, oldest first
+func (c *Cache) answers(name string, rrtype uint16) []dns.RR {
+	entries := c.lookup(name, rrtype, false)
+	current := entries[:0]
+	for _, e := range entries {
+		if !e.flushed {
+			current = append(current, e)
+		}
+	}
+	return entryRecords(current)
+}
+
+// lookup returns copies of the unexpired entries for name and type in
+// the order they were received, an empty name matches every name. fresh
+// only returns the entries not being flushed with more than half their
+// original TTL left, RFC 6762 section 7.1 "a Multicast DNS querier SHOULD
+// NOT include records in the Known-Answer list whose remaining TTL is less
+// than half of their original TTL"
+func (c *Cache) lookup(name string, rrtype uint16, fresh bool) []CacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	c.purge(now)
+	entries := make([]CacheEntry, 0)
+	for _, e := range c.entries {
+		h := e.RR.Header()
+		if rrtype != dns.TypeANY && h.Rrtype != rrtype {
+			continue
+		}
+		if name != "" && !strings.EqualFold(h.Name, name) {
+			continue
+		}
+		remaining := e.Expires.Sub(now)
+		if fresh && (e.flushed || remaining*2 <= time.Duration(h.Ttl)*time.Second) {
+			continue
+		}
+
+		entry := *e
+		entry.RR = dns.Copy(e.RR)
+		entry.RR.Header().Ttl = uint32(remaining / time.Second)
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entryBefore(&entries[i], &entries[j])
+	})
+	return entries
+}
+
+// entryBefore orders entries by the time they were received, then by
+// name and record data
+func entryBefore(a, b *CacheEntry) bool {
+	if !a.Received.Equal(b.Received) {
+		return a.Received.Before(b.Received)
+	}
+	an, bn := strings.ToLower(a.RR.Header().Name), strings.ToLower(b.RR.Header().Name)
+	if an != bn {
+		return an < bn
+	}
+	return compareRecords(a.RR, b.RR) < 0
+}
+
+// knownAnswers returns the records for name and type to list in the
+// Known-Answer section of a query, responders will not send them again
+func (c *Cache) knownAnswers(name string, rrtype uint16) []dns.RR {
+	return entryRecords(c.lookup(name, rrtype, true))
+}
+
+// additionals returns the fresh records that go with answers, the A
+// record of SRV targets and the SRV, TXT and A records of PTR instances
+func (c *Cache) additionals(answers []dns.RR) []dns.RR {
+	extra := make([]dns.RR, 0)
+	for _, rr := range answers {
+		switch rr := rr.(type) {
+		case *dns.SRV:
+			extra = appendRecords(extra, c.knownAnswers(rr.Target, dns.TypeA)...)
+		case *dns.PTR:
+			for _, instance := range c.knownAnswers(rr.Ptr, dns.TypeANY) {
+				extra = appendRecords(extra, instance)
+				if srv, ok := instance.(*dns.SRV); ok {
+					extra = appendRecords(extra, c.knownAnswers(srv.Target, dns.TypeA)...)
+				}
+			}
+		}
+	}
+	return extra
+}
+
+// purge removes the expired entries, must be called with the lock held
+func (c *Cache) purge(now time.Time) {
+	for key, e := range c.entries {
+		if !now.Before(e.Expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.lastPurge = now
+}
+
+// entryRecords returns the records of entries
+func entryRecords(entries []CacheEntry) []dns.RR {
+	rrs := make([]dns.RR, 0, len(entries))
+	for _, e := range entries {
+		rrs = append(rrs, e.RR)
+	}
+	return rrs
+}
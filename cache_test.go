@@ -0,0 +1,254 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCacheAdd(t *testing.T) {
+	type received struct {
+		rr  string
+		age time.Duration
+	}
+	type cached struct {
+		rr      string
+		flushed bool
+	}
+	tests := []struct {
+		name     string
+		received []received
+		want     []cached
+	}{
+		{
+			name:     "stored",
+			received: []received{{rr: "host.local. 120 IN A 10.0.0.1"}},
+			want:     []cached{{rr: "host.local. 120 IN A 10.0.0.1"}},
+		},
+		{
+			name:     "goodbye expires the record in one second",
+			received: []received{{rr: "host.local. 120 IN A 10.0.0.1"}, {rr: "host.local. 0 IN A 10.0.0.1"}},
+			want:     []cached{{rr: "host.local. 120 IN A 10.0.0.1", flushed: true}},
+		},
+		{
+			name:     "goodbye for a record not cached",
+			received: []received{{rr: "host.local. 0 IN A 10.0.0.1"}},
+		},
+		{
+			name: "goodbye only for its rdata",
+			received: []received{
+				{rr: "host.local. 120 IN A 10.0.0.1"},
+				{rr: "host.local. 120 IN A 10.0.0.2"},
+				{rr: "host.local. 0 IN A 10.0.0.1"},
+			},
+			want: []cached{
+				{rr: "host.local. 120 IN A 10.0.0.1", flushed: true},
+				{rr: "host.local. 120 IN A 10.0.0.2"},
+			},
+		},
+		{
+			name: "cache-flush flushes records older than one second",
+			received: []received{
+				{rr: "host.local. 120 IN A 10.0.0.1", age: 2 * time.Second},
+				{rr: "host.local. 120 CLASS32769 A 10.0.0.2"},
+			},
+			want: []cached{
+				{rr: "host.local. 120 IN A 10.0.0.1", flushed: true},
+				{rr: "host.local. 120 IN A 10.0.0.2"},
+			},
+		},
+		{
+			name: "cache-flush keeps records from the same response",
+			received: []received{
+				{rr: "host.local. 120 CLASS32769 A 10.0.0.1"},
+				{rr: "host.local. 120 CLASS32769 A 10.0.0.2"},
+			},
+			want: []cached{
+				{rr: "host.local. 120 IN A 10.0.0.1"},
+				{rr: "host.local. 120 IN A 10.0.0.2"},
+			},
+		},
+		{
+			name: "cache-flush refreshes the same record",
+			received: []received{
+				{rr: "host.local. 120 IN A 10.0.0.1", age: 2 * time.Second},
+				{rr: "host.local. 120 CLASS32769 A 10.0.0.1"},
+			},
+			want: []cached{{rr: "host.local. 120 IN A 10.0.0.1"}},
+		},
+		{
+			name: "cache-flush ignores name case",
+			received: []received{
+				{rr: "HOST.local. 120 IN A 10.0.0.1", age: 2 * time.Second},
+				{rr: "host.local. 120 CLASS32769 A 10.0.0.2"},
+			},
+			want: []cached{
+				{rr: "HOST.local. 120 IN A 10.0.0.1", flushed: true},
+				{rr: "host.local. 120 IN A 10.0.0.2"},
+			},
+		},
+		{
+			name: "cache-flush keeps other types and names",
+			received: []received{
+				{rr: `host.local. 120 IN TXT "a"`, age: 2 * time.Second},
+				{rr: "other.local. 120 IN A 10.0.0.1", age: 2 * time.Second},
+				{rr: "host.local. 120 CLASS32769 A 10.0.0.2"},
+			},
+			want: []cached{
+				{rr: `host.local. 120 IN TXT "a"`},
+				{rr: "other.local. 120 IN A 10.0.0.1"},
+				{rr: "host.local. 120 IN A 10.0.0.2"},
+			},
+		},
+	}
+
+	p := packet{src: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: mdnsPort}, ifIndex: 1}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCache()
+			for _, r := range tt.received {
+				rr := mustRR(t, r.rr)
+				c.add(rr, p)
+				if e, ok := c.entries[recordKey(rr)]; ok && r.age > 0 {
+					e.Received = e.Received.Add(-r.age)
+				}
+			}
+
+			if c.Len() != len(tt.want) {
+				t.Errorf("Len() = %d, want %d", c.Len(), len(tt.want))
+			}
+			now := time.Now()
+			for _, w := range tt.want {
+				e, ok := c.entries[recordKey(mustRR(t, w.rr))]
+				if !ok {
+					t.Errorf("%s: not cached", w.rr)
+					continue
+				}
+				if e.RR.Header().Class != dns.ClassINET {
+					t.Errorf("%s: class %d, want the cache-flush bit cleared", w.rr, e.RR.Header().Class)
+				}
+				if flushed := e.Expires.Sub(now) <= cacheFlushDelay; flushed != w.flushed {
+					t.Errorf("%s: expires in %v, want flushed %v", w.rr, e.Expires.Sub(now), w.flushed)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheAnswers(t *testing.T) {
+	type received struct {
+		rr  string
+		age time.Duration
+	}
+	tests := []struct {
+		name     string
+		received []received
+		want     []string
+	}{
+		{
+			name: "oldest first",
+			received: []received{
+				{rr: "host.local. 120 IN A 10.0.0.3", age: 3 * time.Second},
+				{rr: "host.local. 120 IN A 10.0.0.1", age: 2 * time.Second},
+				{rr: "host.local. 120 IN A 10.0.0.2", age: 1 * time.Second},
+			},
+			want: []string{"host.local. 120 IN A 10.0.0.3", "host.local. 120 IN A 10.0.0.1", "host.local. 120 IN A 10.0.0.2"},
+		},
+		{
+			name: "flushed by a cache-flush record",
+			received: []received{
+				{rr: "cat.local. 120 IN A 10.0.0.1", age: 2 * time.Second},
+				{rr: "cat.local. 120 CLASS32769 A 10.0.0.2"},
+			},
+			want: []string{"cat.local. 120 IN A 10.0.0.2"},
+		},
+		{
+			name: "flushed by a goodbye",
+			received: []received{
+				{rr: "cat.local. 120 IN A 10.0.0.1"},
+				{rr: "cat.local. 120 IN A 10.0.0.2"},
+				{rr: "cat.local. 0 IN A 10.0.0.2"},
+			},
+			want: []string{"cat.local. 120 IN A 10.0.0.1"},
+		},
+		{
+			name: "other types left out",
+			received: []received{
+				{rr: `cat.local. 120 IN TXT "a"`},
+				{rr: "cat.local. 120 IN A 10.0.0.1"},
+			},
+			want: []string{"cat.local. 120 IN A 10.0.0.1"},
+		},
+	}
+
+	p := packet{src: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: mdnsPort}, ifIndex: 1}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCache()
+			for _, r := range tt.received {
+				rr := mustRR(t, r.rr)
+				c.add(rr, p)
+				if e, ok := c.entries[recordKey(rr)]; ok && r.age > 0 {
+					e.Received = e.Received.Add(-r.age)
+				}
+			}
+
+			rr := mustRR(t, tt.want[0])
+			for i := 0; i < 10; i++ {
+				got := c.answers(rr.Header().Name, rr.Header().Rrtype)
+				if !sameRecords(got, mustRRs(t, tt.want...)) {
+					t.Fatalf("answers() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheLimits(t *testing.T) {
+	p := packet{src: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: mdnsPort}, ifIndex: 1}
+
+	t.Run("TTL clamped", func(t *testing.T) {
+		c := newCache()
+		c.add(mustRR(t, "host.local. 4294967295 IN A 10.0.0.1"), p)
+		got := c.Lookup("host.local.", dns.TypeA)
+		if len(got) != 1 || got[0].Header().Ttl > maxCacheTTL {
+			t.Errorf("Lookup() = %v, want one record with a TTL of at most %d", got, maxCacheTTL)
+		}
+		if known := c.knownAnswers("host.local.", dns.TypeA); len(known) != 1 {
+			t.Errorf("knownAnswers() = %v, want the clamped record", known)
+		}
+	})
+
+	t.Run("full cache evicts the entry closest to expiry", func(t *testing.T) {
+		c := newCache()
+		for _, rr := range hostRecords(t, maxCacheEntries-1, 120, "IN") {
+			c.add(rr, p)
+		}
+		c.add(mustRR(t, "short.local. 10 IN A 10.1.0.1"), p)
+		c.add(mustRR(t, "new.local. 120 IN A 10.1.0.2"), p)
+
+		if c.Len() != maxCacheEntries {
+			t.Errorf("Len() = %d, want %d", c.Len(), maxCacheEntries)
+		}
+		if got := c.Lookup("short.local.", dns.TypeA); len(got) != 0 {
+			t.Errorf("short.local. still cached")
+		}
+		if got := c.Lookup("new.local.", dns.TypeA); len(got) != 1 {
+			t.Errorf("new.local. not cached")
+		}
+	})
+
+	t.Run("refreshing a cached record does not evict", func(t *testing.T) {
+		c := newCache()
+		rrs := hostRecords(t, maxCacheEntries, 120, "IN")
+		for _, rr := range rrs {
+			c.add(rr, p)
+		}
+		c.add(rrs[0], p)
+		if c.Len() != maxCacheEntries {
+			t.Errorf("Len() = %d, want %d", c.Len(), maxCacheEntries)
+		}
+	})
+}
@@ -2,6 +2,8 @@ package mdns
 
 import (
 	"net"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,14 +19,48 @@ const (
 // Config is used to configure a mDNS client or server.
 type Config struct {
 	sync.RWMutex
-	// QueryInterval controls how often we sends Queries until we
-	// get a response
-	QueryInterval time.Duration
+	// QueryInterval is the interval between the first two Queries we
+	// send until we get a response, 1 second by default. It doubles after
+	// each Query up to MaxQueryInterval, 60 minutes by default
+	QueryInterval    time.Duration
+	MaxQueryInterval time.Duration
 
 	// LocalNames are the names that we will generate answers for
 	// when we get questions
 	ARecords   []DynamicARR
 	SRVRecords []dns.SRV
+	TXTRecords []dns.TXT
+
+	// PTRRecords are shared records, other hosts answer for the same
+	// names so they are not probed
+	PTRRecords []dns.PTR
+
+	// AllowOffLinkUnicast answers queries sent to us by unicast from
+	// off-link, by default they are ignored as RFC 6762 section 5.5
+	// recommends. Multicast packets and responses from off-link are
+	// always ignored
+	AllowOffLinkUnicast bool
+
+	// Announcements is the number of unsolicited responses sent when
+	// records are claimed or change, between 2 (the default) and 8
+	Announcements int
+
+	// ProbeCallback is called when probing for a name finishes, with the
+	// new name if the records were renamed. err is nil if the name is ours
+	// or ErrNameConflict if another host owns it and it cannot be renamed
+	ProbeCallback func(name string, err error)
+
+	// ConflictCallback is called when another host answers with different
+	// data for one of our records, the name is probed again after that
+	ConflictCallback func(Conflict)
+
+	// RenameCallback is called with the old and new name when a name is
+	// lost to another host and our records were renamed
+	RenameCallback func(oldName, newName string)
+
+	// tentative holds the names still being probed, we do not answer
+	// questions for them until probing succeeds
+	tentative map[string]bool
 }
 
 // DynamicARR allow creating A Records that will change ip address
@@ -34,35 +70,85 @@ type DynamicARR struct {
 	Dynamic bool
 }
 
-// RemoveARecord remove a record for the configuration based on name
-func (c *Config) removeARecord(name string) error {
+// RemoveARecord remove a record for the configuration based on name,
+// returns the removed record
+func (c *Config) removeARecord(name string) (*DynamicARR, error) {
+	name = addDot(name)
+
 	c.Lock()
 	defer c.Unlock()
 
 	for i := len(c.ARecords) - 1; i >= 0; i-- {
 
-		if c.ARecords[i].Header().Name == name {
-			c.ARecords = append(c.ARecords[:i], c.ARecords[i+1])
+		if strings.EqualFold(c.ARecords[i].Header().Name, name) {
+			rec := c.ARecords[i]
+			c.ARecords = append(c.ARecords[:i], c.ARecords[i+1:]...)
 			Log().Debug("Removed A record", zap.String("name", name))
-			return nil
+			return &rec, nil
 		}
 	}
-	return errRecordNotFound
+	return nil, errRecordNotFound
 }
 
-// RemoveSRVRecord remove a srv record from configuration
-func (c *Config) removeSRVRecord(name string) error {
+// RemoveSRVRecord remove a srv record from configuration, returns the
+// removed record
+func (c *Config) removeSRVRecord(name string) (*dns.SRV, error) {
+	name = addDot(name)
+
 	c.Lock()
 	defer c.Unlock()
 
 	for i := len(c.SRVRecords) - 1; i >= 0; i-- {
-		if c.SRVRecords[i].Header().Name == name {
-			c.SRVRecords = append(c.SRVRecords[:i], c.SRVRecords[i+1])
-			Log().Debug("Added SRV record", zap.String("name", name))
-			return nil
+		if strings.EqualFold(c.SRVRecords[i].Header().Name, name) {
+			rec := c.SRVRecords[i]
+			c.SRVRecords = append(c.SRVRecords[:i], c.SRVRecords[i+1:]...)
+			Log().Debug("Removed SRV record", zap.String("name", name))
+			return &rec, nil
 		}
 	}
-	return errRecordNotFound
+	return nil, errRecordNotFound
+}
+
+// removeTXTRecord remove a txt record from configuration, returns the
+// removed record
+func (c *Config) removeTXTRecord(name string) (*dns.TXT, error) {
+	name = addDot(name)
+
+	c.Lock()
+	defer c.Unlock()
+
+	for i := len(c.TXTRecords) - 1; i >= 0; i-- {
+		if strings.EqualFold(c.TXTRecords[i].Header().Name, name) {
+			rec := c.TXTRecords[i]
+			c.TXTRecords = append(c.TXTRecords[:i], c.TXTRecords[i+1:]...)
+			Log().Debug("Removed TXT record", zap.String("name", name))
+			return &rec, nil
+		}
+	}
+	return nil, errRecordNotFound
+}
+
+// removePTRRecord removes the PTR record pointing name at target, returns
+// the removed record
+func (c *Config) removePTRRecord(name, target string) (*dns.PTR, error) {
+	if name == "" || target == "" {
+		return nil, errInvalidParameter
+	}
+	name = addDot(name)
+	target = addDot(target)
+
+	c.Lock()
+	defer c.Unlock()
+
+	for i := len(c.PTRRecords) - 1; i >= 0; i-- {
+		if strings.EqualFold(c.PTRRecords[i].Header().Name, name) && strings.EqualFold(c.PTRRecords[i].Ptr, target) {
+			rec := c.PTRRecords[i]
+			c.PTRRecords = append(c.PTRRecords[:i], c.PTRRecords[i+1:]...)
+			Log().Debug("Removed PTR record", zap.String("name", name), zap.String("target", target))
+			return &rec, nil
+		}
+	}
+	return nil, errRecordNotFound
 }
 
 // AddARecord adds a A record
@@ -121,15 +207,105 @@ func (c *Config) addSRVRecord(name string, priority, weight, port uint16, target
 	return nil
 }
 
+// updateARecord changes the address of an existing A record, the same
+// rules as addARecord apply to dst and dyn
+func (c *Config) updateARecord(name string, dst *net.IP, dyn bool) error {
+	if name == "" {
+		return errInvalidParameter
+	}
+	name = addDot(name)
+
+	c.Lock()
+	defer c.Unlock()
+	for i := range c.ARecords {
+		if strings.EqualFold(c.ARecords[i].Header().Name, name) {
+			if !dyn && dst != nil {
+				c.ARecords[i].A.A = *dst
+				c.ARecords[i].Dynamic = false
+			} else {
+				c.ARecords[i].Dynamic = true
+			}
+			Log().Debug("Updated A record", zap.String("name", c.ARecords[i].String()))
+			return nil
+		}
+	}
+	return errRecordNotFound
+}
+
+// updateSRVRecord changes the data of an existing SRV record
+func (c *Config) updateSRVRecord(name string, priority, weight, port uint16, target string) error {
+	if name == "" || target == "" {
+		return errInvalidParameter
+	}
+	name = addDot(name)
+
+	c.Lock()
+	defer c.Unlock()
+	for i := range c.SRVRecords {
+		if strings.EqualFold(c.SRVRecords[i].Header().Name, name) {
+			c.SRVRecords[i].Priority = priority
+			c.SRVRecords[i].Weight = weight
+			c.SRVRecords[i].Port = port
+			c.SRVRecords[i].Target = addDot(target)
+			Log().Debug("Updated SRV record", zap.String("name", name), zap.String("target", target))
+			return nil
+		}
+	}
+	return errRecordNotFound
+}
+
+// addTXTRecord adds a TXT record with the strings txt
+func (c *Config) addTXTRecord(name string, txt []string) error {
+	if name == "" {
+		return errInvalidParameter
+	}
+	name = addDot(name)
+	rec := c.createTXTRecord(name, txt)
+
+	c.Lock()
+	defer c.Unlock()
+	for i := len(c.TXTRecords) - 1; i >= 0; i-- {
+		if strings.EqualFold(c.TXTRecords[i].Header().Name, name) { // Record already there
+			return errRecordExists
+		}
+	}
+	c.TXTRecords = append(c.TXTRecords, *rec)
+	c.setTentative(name)
+	Log().Debug("Added TXT record", zap.String("name", name))
+	return nil
+}
+
+// addPTRRecord adds a PTR record pointing name at target
+func (c *Config) addPTRRecord(name, target string) error {
+	if name == "" || target == "" {
+		return errInvalidParameter
+	}
+	name = addDot(name)
+	target = addDot(target)
+	rec := c.createPTRRecord(name, target)
+
+	c.Lock()
+	defer c.Unlock()
+	for i := len(c.PTRRecords) - 1; i >= 0; i-- {
+		if strings.EqualFold(c.PTRRecords[i].Header().Name, name) && strings.EqualFold(c.PTRRecords[i].Ptr, target) { // Record already there
+			return errRecordExists
+		}
+	}
+	c.PTRRecords = append(c.PTRRecords, *rec)
+	Log().Debug("Added PTR record", zap.String("name", name), zap.String("target", target))
+	return nil
+}
+
 func (c *Config) addARecordToConfig(rec *DynamicARR) error {
 	c.Lock()
 	defer c.Unlock()
 	for i := len(c.ARecords) - 1; i >= 0; i-- {
-		if c.ARecords[i].Header().Name == rec.Header().Name { // Record already there
+		if strings.EqualFold(c.ARecords[i].Header().Name, rec.Header().Name) { // Record already there
 			return errRecordExists
 		}
 	}
 	c.ARecords = append(c.ARecords, *rec)
+	c.setTentative(rec.Header().Name)
 	return nil
 }
 
@@ -137,14 +313,315 @@ func (c *Config) addSRVRecordToConfig(rec *dns.SRV) error {
 	c.Lock()
 	defer c.Unlock()
 	for i := len(c.SRVRecords) - 1; i >= 0; i-- {
-		if c.SRVRecords[i].Header().Name == rec.Header().Name { // Record already there
+		if strings.EqualFold(c.SRVRecords[i].Header().Name, rec.Header().Name) { // Record already there
 			return errRecordExists
 		}
 	}
 	c.SRVRecords = append(c.SRVRecords, *rec)
+	c.setTentative(rec.Header().Name)
 	return nil
 }
 
+// setTentative marks a name as being probed, must be called with the lock held
+func (c *Config) setTentative(name string) {
+	if c.tentative == nil {
+		c.tentative = make(map[string]bool)
+	}
+	c.tentative[strings.ToLower(name)] = true
+}
+
+// setConfiguredTentative marks the names of the records placed in the
+// Config directly as being probed, like the ones added through the Conn,
+// and makes their names fully qualified
+func (c *Config) setConfiguredTentative() {
+	c.Lock()
+	defer c.Unlock()
+	for i := range c.ARecords {
+		c.ARecords[i].Hdr.Name = addDot(c.ARecords[i].Hdr.Name)
+	}
+	for i := range c.SRVRecords {
+		c.SRVRecords[i].Hdr.Name = addDot(c.SRVRecords[i].Hdr.Name)
+		c.SRVRecords[i].Target = addDot(c.SRVRecords[i].Target)
+	}
+	for i := range c.TXTRecords {
+		c.TXTRecords[i].Hdr.Name = addDot(c.TXTRecords[i].Hdr.Name)
+	}
+	for i := range c.PTRRecords {
+		c.PTRRecords[i].Hdr.Name = addDot(c.PTRRecords[i].Hdr.Name)
+		c.PTRRecords[i].Ptr = addDot(c.PTRRecords[i].Ptr)
+	}
+	for _, name := range c.uniqueNames() {
+		c.setTentative(name)
+	}
+}
+
+// isTentative returns true if the name is still being probed, must be
+// called with the lock held
+func (c *Config) isTentative(name string) bool {
+	return c.tentative[strings.ToLower(name)]
+}
+
+// tentativeNames returns the names that are waiting to be probed
+func (c *Config) tentativeNames() []string {
+	c.RLock()
+	defer c.RUnlock()
+	names := make([]string, 0, len(c.tentative))
+	for _, name := range c.uniqueNames() {
+		if c.isTentative(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// uniqueNames returns the names of all our unique records, must be called
+// with the lock held
+func (c *Config) uniqueNames() []string {
+	names := make([]string, 0)
+	for _, rec := range c.ARecords {
+		names = appendName(names, rec.Header().Name)
+	}
+	for _, rec := range c.SRVRecords {
+		names = appendName(names, rec.Header().Name)
+	}
+	for _, rec := range c.TXTRecords {
+		names = appendName(names, rec.Header().Name)
+	}
+	return names
+}
+
+// setTentativeName puts a name we own back into probing
+func (c *Config) setTentativeName(name string) {
+	c.Lock()
+	defer c.Unlock()
+	c.setTentative(name)
+}
+
+// nextName returns the name to use after losing name to another host,
+// host names become "name-2" and service instances "Name (2)", false is
+// returned if the name cannot be renamed
+func (c *Config) nextName(name string) (string, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	var rename func(string) string
+	for _, rec := range c.ARecords {
+		if strings.EqualFold(rec.Header().Name, name) {
+			rename = renameHost
+		}
+	}
+	// Service instances own SRV and TXT records
+	if rename == nil && c.usesName(name) && hasInstanceLabel(name) {
+		rename = renameInstance
+	}
+	if rename == nil {
+		return "", false
+	}
+
+	// Skip names we already use ourselves
+	for newName := rename(name); ; newName = rename(newName) {
+		if !c.usesName(newName) {
+			return newName, true
+		}
+	}
+}
+
+// usesName returns true if we have records for name, must be called with
+// the lock held
+func (c *Config) usesName(name string) bool {
+	for _, n := range c.uniqueNames() {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// renameName moves the records of oldName to newName and points SRV and
+// PTR targets at the new name, newName is tentative until probed. Returns
+// the names of the SRV and PTR records whose target changed and copies of
+// the PTR records as they were before
+func (c *Config) renameName(oldName, newName string) ([]string, []dns.RR) {
+	c.Lock()
+	defer c.Unlock()
+
+	for i := range c.ARecords {
+		if strings.EqualFold(c.ARecords[i].Header().Name, oldName) {
+			c.ARecords[i].Hdr.Name = newName
+		}
+	}
+	retargeted := make([]string, 0)
+	for i := range c.SRVRecords {
+		if strings.EqualFold(c.SRVRecords[i].Header().Name, oldName) {
+			c.SRVRecords[i].Hdr.Name = newName
+		}
+		if strings.EqualFold(c.SRVRecords[i].Target, oldName) {
+			c.SRVRecords[i].Target = newName
+			if !c.isTentative(c.SRVRecords[i].Header().Name) {
+				retargeted = appendName(retargeted, c.SRVRecords[i].Header().Name)
+			}
+		}
+	}
+	for i := range c.TXTRecords {
+		if strings.EqualFold(c.TXTRecords[i].Header().Name, oldName) {
+			c.TXTRecords[i].Hdr.Name = newName
+		}
+	}
+	// Browsing must find the service instance under its new name
+	oldPTRs := make([]dns.RR, 0)
+	for i := range c.PTRRecords {
+		if strings.EqualFold(c.PTRRecords[i].Ptr, oldName) {
+			ptr := c.PTRRecords[i]
+			oldPTRs = append(oldPTRs, &ptr)
+			c.PTRRecords[i].Ptr = newName
+			retargeted = appendName(retargeted, c.PTRRecords[i].Header().Name)
+		}
+	}
+
+	delete(c.tentative, strings.ToLower(oldName))
+	c.setTentative(newName)
+	Log().Debug("Renamed records", zap.String("name", oldName), zap.String("new name", newName))
+	return retargeted, oldPTRs
+}
+
+// isTentativeName returns true if the name is still being probed
+func (c *Config) isTentativeName(name string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.isTentative(name)
+}
+
+// establishName marks a name as ours once probing succeeds
+func (c *Config) establishName(name string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.tentative, strings.ToLower(name))
+}
+
+// removeName removes all the records for a name we failed to claim
+func (c *Config) removeName(name string) {
+	c.Lock()
+	defer c.Unlock()
+	for i := len(c.ARecords) - 1; i >= 0; i-- {
+		if strings.EqualFold(c.ARecords[i].Header().Name, name) {
+			c.ARecords = append(c.ARecords[:i], c.ARecords[i+1:]...)
+		}
+	}
+	for i := len(c.SRVRecords) - 1; i >= 0; i-- {
+		if strings.EqualFold(c.SRVRecords[i].Header().Name, name) {
+			c.SRVRecords = append(c.SRVRecords[:i], c.SRVRecords[i+1:]...)
+		}
+	}
+	for i := len(c.TXTRecords) - 1; i >= 0; i-- {
+		if strings.EqualFold(c.TXTRecords[i].Header().Name, name) {
+			c.TXTRecords = append(c.TXTRecords[:i], c.TXTRecords[i+1:]...)
+		}
+	}
+	delete(c.tentative, strings.ToLower(name))
+	Log().Debug("Removed records", zap.String("name", name))
+}
+
+// isEstablished returns true if we own records for the name and probing finished
+func (c *Config) isEstablished(name string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	return !c.isTentative(name) && c.usesName(name)
+}
+
+// establishedRecords returns a copy of the records of every name we own,
+// dynamic A records get the address ip, or are skipped if ip is nil
+func (c *Config) establishedRecords(ip net.IP) []dns.RR {
+	c.RLock()
+	defer c.RUnlock()
+
+	records := make([]dns.RR, 0)
+	for _, name := range c.uniqueNames() {
+		if !c.isTentative(name) {
+			records = append(records, c.records(name, ip)...)
+		}
+	}
+	return records
+}
+
+// sharedRecordsFor returns a copy of our shared records for a name
+func (c *Config) sharedRecordsFor(name string) []dns.RR {
+	c.RLock()
+	defer c.RUnlock()
+
+	records := make([]dns.RR, 0)
+	for _, rec := range c.PTRRecords {
+		if strings.EqualFold(rec.Header().Name, name) {
+			ptr := rec
+			records = append(records, &ptr)
+		}
+	}
+	return records
+}
+
+// sharedNames returns the names of all our shared records
+func (c *Config) sharedNames() []string {
+	c.RLock()
+	defer c.RUnlock()
+
+	names := make([]string, 0)
+	for _, rec := range c.PTRRecords {
+		names = appendName(names, rec.Header().Name)
+	}
+	return names
+}
+
+// sharedRecords returns a copy of all our shared records
+func (c *Config) sharedRecords() []dns.RR {
+	c.RLock()
+	defer c.RUnlock()
+
+	records := make([]dns.RR, 0, len(c.PTRRecords))
+	for _, rec := range c.PTRRecords {
+		ptr := rec
+		records = append(records, &ptr)
+	}
+	return records
+}
+
+// recordsFor returns a copy of all our unique records for a name, dynamic A
+// records get the address ip, or are skipped if ip is nil
+func (c *Config) recordsFor(name string, ip net.IP) []dns.RR {
+	c.RLock()
+	defer c.RUnlock()
+	return c.records(name, ip)
+}
+
+// records does the work of recordsFor, must be called with the lock held
+func (c *Config) records(name string, ip net.IP) []dns.RR {
+	records := make([]dns.RR, 0)
+	for _, rec := range c.ARecords {
+		if !strings.EqualFold(rec.Header().Name, name) {
+			continue
+		}
+		a := rec.A // shallow copy
+		if rec.Dynamic {
+			if ip == nil {
+				continue
+			}
+			a.A = ip
+		}
+		records = append(records, &a)
+	}
+	for _, rec := range c.SRVRecords {
+		if strings.EqualFold(rec.Header().Name, name) {
+			srv := rec
+			records = append(records, &srv)
+		}
+	}
+	for _, rec := range c.TXTRecords {
+		if strings.EqualFold(rec.Header().Name, name) {
+			txt := rec
+			records = append(records, &txt)
+		}
+	}
+	return records
+}
+
 func (c *Config) createSimpleARecord(name string) (*DynamicARR, error) {
 	rec := &DynamicARR{
 		A: dns.A{
@@ -166,6 +643,7 @@ func (c *Config) createSRVRecord(name string, priority, weight, port uint16, tar
 			Name:   name,
 			Rrtype: dns.TypeSRV,
 			Class:  dns.ClassINET,
+			Ttl:    responseTTL,
 		},
 		Port:     port,
 		Priority: priority,
@@ -175,52 +653,191 @@ func (c *Config) createSRVRecord(name string, priority, weight, port uint16, tar
 	return rec, nil
 }
 
-// Lookup look up A and SRV records, allow for recursion in SVR record
+func (c *Config) createTXTRecord(name string, txt []string) *dns.TXT {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    responseTTL,
+		},
+		Txt: txt,
+	}
+}
+
+func (c *Config) createPTRRecord(name, target string) *dns.PTR {
+	return &dns.PTR{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    responseTTL,
+		},
+		Ptr: target,
+	}
+}
+
+// Lookup look up A, SRV, TXT and PTR records for a question from src,
+// the records the querier will need next are added to answers too.
+// Dynamic A records get the address we use to reach src
 func (c *Config) Lookup(answers *[]dns.RR, q *dns.Question, src net.Addr) error {
+	var rec DynamicARR
+	if err := rec.AddDynamicIP(src); err != nil {
+		return err
+	}
+	return c.LookupRecords(answers, answers, q, rec.A.A)
+}
+
+// LookupRecords look up A, SRV, TXT and PTR records, the records the
+// querier will need next go in extra, the Additional section: the address
+// of the target of a SRV record, and the SRV, TXT and addresses of the
+// instances a PTR record points to (RFC 6763 section 12). Dynamic A records
+// get the address ip, our address on the interface the question came in
+// from, or are skipped if ip is nil
+func (c *Config) LookupRecords(answers, extra *[]dns.RR, q *dns.Question, ip net.IP) error {
 	c.RLock()
 	defer c.RUnlock()
 
+	c.lookup(answers, extra, q, ip)
+	return nil // Is not an error if not found
+}
+
+// lookup does the work of Lookup, must be called with the lock held
+func (c *Config) lookup(answers, extra *[]dns.RR, q *dns.Question, ip net.IP) {
+	// Names still being probed are not ours yet
+	if c.isTentative(q.Name) {
+		return
+	}
+
+	// The top bit of the class asks for a unicast response, it is not
+	// part of the class
+	switch q.Qclass &^ qclassUnicastResponse {
+	case dns.ClassINET, dns.ClassANY:
+	default:
+		return
+	}
+
 	switch q.Qtype {
-	case dns.TypeA:
-		if rec := c.lookupA(q.Name); rec != nil {
-			// create default message and fill out values
-			if rec.Dynamic {
-				if err := rec.AddDynamicIP(src); err != nil {
-					Log().Debug("Error", zap.Error(err))
-					return err
-				}
-			}
-			*answers = append(*answers, rec)
-			return nil
+	case dns.TypeANY:
+		// "ANY" asks for every record we own for the name
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeSRV, dns.TypeTXT, dns.TypePTR} {
+			question := dns.Question{Name: q.Name, Qtype: qtype, Qclass: q.Qclass}
+			c.lookup(answers, extra, &question, ip)
 		}
 
+	case dns.TypeA:
+		c.lookupAddress(answers, q.Name, ip)
+
 	case dns.TypeSRV:
 		if rec := c.lookupSRV(q.Name); rec != nil {
-			// Find A Records if available and add to answers ( A Records )
+			*answers = append(*answers, rec)
+			// Find A Records of the target if available and add to extra
+			c.lookupAddress(extra, rec.Target, ip)
+		}
 
-			newQ := dns.Question{
-				Name:   rec.Target, // Recursive based on the target of the SVR Record
-				Qtype:  dns.TypeA,
-				Qclass: rec.Header().Class,
-			}
+	case dns.TypeTXT:
+		if rec := c.lookupTXT(q.Name); rec != nil {
 			*answers = append(*answers, rec)
+		}
 
-			if err := c.Lookup(answers, &newQ, src); err != nil {
-				return err
+	case dns.TypePTR:
+		for _, rec := range c.lookupPTR(q.Name) {
+			*answers = append(*answers, rec)
+			c.lookupInstance(extra, rec.Ptr, ip)
+		}
+	}
+}
+
+// lookupAddress adds the A record for name to records, dynamic records get
+// the address ip. Must be called with the lock held
+func (c *Config) lookupAddress(records *[]dns.RR, name string, ip net.IP) {
+	if c.isTentative(name) {
+		return
+	}
+	if rec := c.lookupA(name); rec != nil {
+		if rec.Dynamic {
+			// The same address we probe and announce with on the interface
+			if ip == nil {
+				return
 			}
+			rec.A.A = ip
+		}
+		*records = append(*records, rec)
+	}
+}
 
-			return nil
+// lookupInstance adds the SRV, TXT and address records of a service
+// instance to records, must be called with the lock held
+func (c *Config) lookupInstance(records *[]dns.RR, name string, ip net.IP) {
+	if c.isTentative(name) {
+		return
+	}
+	if rec := c.lookupTXT(name); rec != nil {
+		*records = append(*records, rec)
+	}
+	if rec := c.lookupSRV(name); rec != nil {
+		*records = append(*records, rec)
+		c.lookupAddress(records, rec.Target, ip)
+	}
+}
+
+// lookupNSEC returns a NSEC record listing the types we have for a name we
+// own, or nil if we are not authoritative for the name
+func (c *Config) lookupNSEC(qName string) *dns.NSEC {
+	c.RLock()
+	defer c.RUnlock()
 
+	if c.isTentative(qName) {
+		return nil
+	}
+
+	// Any address will do, we only look at the types
+	records := c.records(qName, net.IPv4zero)
+	if len(records) == 0 {
+		return nil
+	}
+	name := records[0].Header().Name
+	types := make([]uint16, 0)
+	for _, rr := range records {
+		types = appendType(types, rr.Header().Rrtype)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    responseTTL,
+		},
+		NextDomain: name,
+		TypeBitMap: types,
+	}
+}
+
+// additionalNSEC returns the NSEC records for the names we own in answers,
+// they go in the Additional section to assert which types do not exist
+func (c *Config) additionalNSEC(answers []dns.RR) []dns.RR {
+	names := make([]string, 0)
+	for _, rr := range answers {
+		if !isShared(rr) {
+			names = appendName(names, rr.Header().Name)
 		}
 	}
 
-	return nil // Is not an error if not found
+	extra := make([]dns.RR, 0)
+	for _, name := range names {
+		if nsec := c.lookupNSEC(name); nsec != nil && !containsRecord(answers, nsec) {
+			extra = append(extra, nsec)
+		}
+	}
+	return extra
 }
 
 // LookupA Records based on name
 func (c *Config) lookupA(qName string) *DynamicARR {
 	for _, aRec := range c.ARecords {
-		if aRec.Header().Name == qName {
+		if strings.EqualFold(aRec.Header().Name, qName) {
 			aRec1 := aRec // shallow copy
 			return &aRec1
 		}
@@ -230,16 +847,37 @@ func (c *Config) lookupA(qName string) *DynamicARR {
 
 // LookupSRV Records based on name
 func (c *Config) lookupSRV(qName string) *dns.SRV {
-	c.RLock()
-	defer c.RUnlock()
 	for _, srvRec := range c.SRVRecords {
-		if srvRec.Header().Name == qName {
+		if strings.EqualFold(srvRec.Header().Name, qName) {
 			return &srvRec
 		}
 	}
 	return nil
 }
 
+// LookupTXT Records based on name
+func (c *Config) lookupTXT(qName string) *dns.TXT {
+	for _, txtRec := range c.TXTRecords {
+		if strings.EqualFold(txtRec.Header().Name, qName) {
+			txtRec1 := txtRec // shallow copy
+			return &txtRec1
+		}
+	}
+	return nil
+}
+
+// LookupPTR Records based on name
+func (c *Config) lookupPTR(qName string) []*dns.PTR {
+	records := make([]*dns.PTR, 0)
+	for _, ptrRec := range c.PTRRecords {
+		if strings.EqualFold(ptrRec.Header().Name, qName) {
+			ptrRec1 := ptrRec // shallow copy
+			records = append(records, &ptrRec1)
+		}
+	}
+	return records
+}
+
 // AddDynamicIP modify the DynamicARR to include the dynamic ip address,
 // return error on error or nil
 func (d *DynamicARR) AddDynamicIP(src net.Addr) error {
@@ -0,0 +1,130 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestConfigRemoveRecords(t *testing.T) {
+	ip := net.IPv4(10, 0, 0, 1)
+	tests := []struct {
+		name   string
+		add    func(c *Config) error
+		remove func(c *Config) error
+	}{
+		{
+			name:   "A without dot",
+			add:    func(c *Config) error { return c.addARecord("catalog.local", &ip, false) },
+			remove: func(c *Config) error { _, err := c.removeARecord("catalog.local"); return err },
+		},
+		{
+			name:   "A with dot",
+			add:    func(c *Config) error { return c.addARecord("catalog.local", &ip, false) },
+			remove: func(c *Config) error { _, err := c.removeARecord("catalog.local."); return err },
+		},
+		{
+			name:   "A other case",
+			add:    func(c *Config) error { return c.addARecord("catalog.local.", &ip, false) },
+			remove: func(c *Config) error { _, err := c.removeARecord("Catalog.local"); return err },
+		},
+		{
+			name:   "SRV without dot",
+			add:    func(c *Config) error { return c.addSRVRecord("Catalog._x._tcp.local", 0, 0, 80, "catalog.local") },
+			remove: func(c *Config) error { _, err := c.removeSRVRecord("Catalog._x._tcp.local"); return err },
+		},
+		{
+			name:   "TXT without dot",
+			add:    func(c *Config) error { return c.addTXTRecord("x.local", []string{"a=b"}) },
+			remove: func(c *Config) error { _, err := c.removeTXTRecord("x.local"); return err },
+		},
+		{
+			name:   "SRV with dot",
+			add:    func(c *Config) error { return c.addSRVRecord("Catalog._x._tcp.local", 0, 0, 80, "catalog.local") },
+			remove: func(c *Config) error { _, err := c.removeSRVRecord("Catalog._x._tcp.local."); return err },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			if err := tt.add(c); err != nil {
+				t.Fatalf("add: %v", err)
+			}
+			if err := tt.remove(c); err != nil {
+				t.Errorf("remove: %v", err)
+			}
+			if err := tt.remove(c); err != errRecordNotFound {
+				t.Errorf("second remove: %v, want %v", err, errRecordNotFound)
+			}
+		})
+	}
+}
+
+func TestConfigLookup(t *testing.T) {
+	ip := net.IPv4(10, 0, 0, 1)
+	c := &Config{}
+	if err := c.addARecord("catalog.local", &ip, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.addARecord("dynamic.local", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.addSRVRecord("Catalog._x._tcp.local", 0, 0, 80, "catalog.local"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"catalog.local.", "dynamic.local.", "Catalog._x._tcp.local."} {
+		c.establishName(name)
+	}
+	src := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: mdnsPort}
+
+	tests := []struct {
+		name        string
+		q           dns.Question
+		wantAnswers []string
+		wantExtra   []string
+	}{
+		{
+			name:        "A",
+			q:           dns.Question{Name: "catalog.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			wantAnswers: []string{"catalog.local. 10 IN A 10.0.0.1"},
+		},
+		{
+			name:        "dynamic A",
+			q:           dns.Question{Name: "dynamic.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			wantAnswers: []string{"dynamic.local. 10 IN A 127.0.0.1"},
+		},
+		{
+			name:        "SRV",
+			q:           dns.Question{Name: "Catalog._x._tcp.local.", Qtype: dns.TypeSRV, Qclass: dns.ClassINET},
+			wantAnswers: []string{"Catalog._x._tcp.local. 10 IN SRV 0 0 80 catalog.local."},
+			wantExtra:   []string{"catalog.local. 10 IN A 10.0.0.1"},
+		},
+		{
+			name: "not found",
+			q:    dns.Question{Name: "other.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var answers, extra []dns.RR
+			if err := c.LookupRecords(&answers, &extra, &tt.q, net.IPv4(127, 0, 0, 1)); err != nil {
+				t.Fatalf("LookupRecords: %v", err)
+			}
+			if !sameRecords(answers, mustRRs(t, tt.wantAnswers...)) || !sameRecords(extra, mustRRs(t, tt.wantExtra...)) {
+				t.Errorf("LookupRecords() = %v, %v, want %v, %v", answers, extra, tt.wantAnswers, tt.wantExtra)
+			}
+
+			// Lookup puts everything in answers
+			var all []dns.RR
+			if err := c.Lookup(&all, &tt.q, src); err != nil {
+				t.Fatalf("Lookup: %v", err)
+			}
+			if want := append(append([]string{}, tt.wantAnswers...), tt.wantExtra...); !sameRecords(all, mustRRs(t, want...)) {
+				t.Errorf("Lookup() = %v, want %v", all, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+var (
+	hostSuffix     = regexp.MustCompile(`^(.*)-([0-9]+)$`)
+	instanceSuffix = regexp.MustCompile(`^(.*) \(([0-9]+)\)$`)
+)
+
+// Conflict describes a record of ours that another host answered for
+// with different data
+type Conflict struct {
+	Name   string
+	Rrtype uint16
+	Src    net.Addr
+}
+
+// checkConflicts implements RFC 6762 section 9, a response with a record
+// that has the same name, type and class as one we own but different rdata
+// is a conflict, the name is probed again and the application notified
+func (c *Conn) checkConflicts(msg dns.Msg, p packet) {
+	records := append(append([]dns.RR{}, msg.Answer...), msg.Extra...)
+	conflicting := make([]string, 0)
+
+	for _, rr := range records {
+		name := rr.Header().Name
+		// Goodbye packets do not claim the name
+		if rr.Header().Ttl == 0 || !c.config.isEstablished(name) {
+			continue
+		}
+		if !c.ownsType(name, rr.Header().Rrtype) || c.isOwnRecord(rr) {
+			continue
+		}
+
+		Log().Debug("Conflicting record received",
+			zap.String("name", name), zap.String("source", p.src.String()))
+		if c.config.ConflictCallback != nil {
+			c.config.ConflictCallback(Conflict{
+				Name:   name,
+				Rrtype: rr.Header().Rrtype,
+				Src:    p.src,
+			})
+		}
+		conflicting = appendName(conflicting, name)
+	}
+
+	for _, name := range conflicting {
+		if c.activeProbe(name) != nil {
+			continue
+		}
+		c.config.setTentativeName(name)
+		go c.probeName(name) //nolint errcheck
+	}
+}
+
+// renameHost turns "catalog.local." into "catalog-2.local." and
+// "catalog-2.local." into "catalog-3.local."
+func renameHost(name string) string {
+	label, rest := splitFirstLabel(name)
+	return bumpSuffix(label, hostSuffix, "%s-%d") + rest
+}
+
+// renameInstance turns "Catalog._catalog._tcp.local." into
+// "Catalog (2)._catalog._tcp.local." and so on
+func renameInstance(name string) string {
+	label, rest := splitFirstLabel(name)
+	return bumpSuffix(label, instanceSuffix, "%s (%d)") + rest
+}
+
+// bumpSuffix increments the number matched by suffix in label, or adds
+// the number 2 if there is none
+func bumpSuffix(label string, suffix *regexp.Regexp, format string) string {
+	if m := suffix.FindStringSubmatch(label); m != nil {
+		if n, err := strconv.Atoi(m[2]); err == nil {
+			return fmt.Sprintf(format, m[1], n+1)
+		}
+	}
+	return fmt.Sprintf(format, label, 2)
+}
+
+// splitFirstLabel splits a name into its first label and the rest of
+// the name, including the separating dot
+func splitFirstLabel(name string) (string, string) {
+	indexes := dns.Split(name)
+	if len(indexes) < 2 {
+		return strings.TrimSuffix(name, "."), "."
+	}
+	return name[:indexes[1]-1], name[indexes[1]-1:]
+}
+
+// hasInstanceLabel returns true if a service name starts with an instance
+// label, "_catalog._tcp.local." has none and cannot be renamed
+func hasInstanceLabel(name string) bool {
+	label, _ := splitFirstLabel(name)
+	return label != "" && !strings.HasPrefix(label, "_")
+}
+
+// ownsType returns true if we have a record of type rrtype for name
+func (c *Conn) ownsType(name string, rrtype uint16) bool {
+	// Any address will do, we only look at the types
+	for _, rr := range c.config.recordsFor(name, net.IPv4zero) {
+		if rr.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package mdns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRenameHost(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"catalog.local.", "catalog-2.local."},
+		{"catalog-2.local.", "catalog-3.local."},
+		{"catalog-9.local.", "catalog-10.local."},
+		{"my-host.local.", "my-host-2.local."},
+		{"host-a.local.", "host-a-2.local."},
+		{"catalog.", "catalog-2."},
+		{"catalog", "catalog-2."},
+	}
+
+	for _, tt := range tests {
+		if got := renameHost(tt.name); got != tt.want {
+			t.Errorf("renameHost(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenameInstance(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Catalog._catalog._tcp.local.", "Catalog (2)._catalog._tcp.local."},
+		{"Catalog (2)._catalog._tcp.local.", "Catalog (3)._catalog._tcp.local."},
+		{"Catalog (9)._catalog._tcp.local.", "Catalog (10)._catalog._tcp.local."},
+		{"Catalog(2)._catalog._tcp.local.", "Catalog(2) (2)._catalog._tcp.local."},
+		{"Catalog 2._catalog._tcp.local.", "Catalog 2 (2)._catalog._tcp.local."},
+	}
+
+	for _, tt := range tests {
+		if got := renameInstance(tt.name); got != tt.want {
+			t.Errorf("renameInstance(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// unescape turns the `Catalog\ \(2\)` names of the zone file format into
+// the "Catalog (2)" that renameInstance makes
+var unescape = strings.NewReplacer(`\ `, " ", `\(`, "(", `\)`, ")")
+
+// mustPlainRRs is mustRRs with the names unescaped
+func mustPlainRRs(t *testing.T, ss ...string) []dns.RR {
+	t.Helper()
+	rrs := mustRRs(t, ss...)
+	for _, rr := range rrs {
+		rr.Header().Name = unescape.Replace(rr.Header().Name)
+		switch rr := rr.(type) {
+		case *dns.SRV:
+			rr.Target = unescape.Replace(rr.Target)
+		case *dns.PTR:
+			rr.Ptr = unescape.Replace(rr.Ptr)
+		}
+	}
+	return rrs
+}
+
+// testConfig returns a Config holding records, none of them tentative
+func testConfig(t *testing.T, records ...string) *Config {
+	t.Helper()
+	c := &Config{}
+	for _, rr := range mustPlainRRs(t, records...) {
+		switch rr := rr.(type) {
+		case *dns.A:
+			c.ARecords = append(c.ARecords, DynamicARR{A: *rr})
+		case *dns.SRV:
+			c.SRVRecords = append(c.SRVRecords, *rr)
+		case *dns.TXT:
+			c.TXTRecords = append(c.TXTRecords, *rr)
+		case *dns.PTR:
+			c.PTRRecords = append(c.PTRRecords, *rr)
+		}
+	}
+	return c
+}
+
+// configRecords returns the A, SRV, TXT and PTR records of c in that order
+func configRecords(c *Config) []dns.RR {
+	records := make([]dns.RR, 0)
+	for i := range c.ARecords {
+		records = append(records, &c.ARecords[i].A)
+	}
+	for i := range c.SRVRecords {
+		records = append(records, &c.SRVRecords[i])
+	}
+	for i := range c.TXTRecords {
+		records = append(records, &c.TXTRecords[i])
+	}
+	for i := range c.PTRRecords {
+		records = append(records, &c.PTRRecords[i])
+	}
+	return records
+}
+
+func TestConfigNextName(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []string
+		lost    string
+		want    string
+		wantOK  bool
+	}{
+		{
+			name:    "host",
+			records: []string{"catalog.local. 120 IN A 10.0.0.1"},
+			lost:    "catalog.local.",
+			want:    "catalog-2.local.",
+			wantOK:  true,
+		},
+		{
+			name:    "host name case",
+			records: []string{"catalog.local. 120 IN A 10.0.0.1"},
+			lost:    "Catalog.local.",
+			want:    "Catalog-2.local.",
+			wantOK:  true,
+		},
+		{
+			name:    "host skips names in use",
+			records: []string{"catalog.local. 120 IN A 10.0.0.1", "catalog-2.local. 120 IN A 10.0.0.2", "Catalog-3.local. 120 IN A 10.0.0.3"},
+			lost:    "catalog.local.",
+			want:    "catalog-4.local.",
+			wantOK:  true,
+		},
+		{
+			name:    "instance",
+			records: []string{"Catalog._x._tcp.local. 120 IN SRV 0 0 80 catalog.local."},
+			lost:    "Catalog._x._tcp.local.",
+			want:    "Catalog (2)._x._tcp.local.",
+			wantOK:  true,
+		},
+		{
+			name:    "instance with TXT only",
+			records: []string{`Catalog._x._tcp.local. 120 IN TXT "a=b"`},
+			lost:    "Catalog._x._tcp.local.",
+			want:    "Catalog (2)._x._tcp.local.",
+			wantOK:  true,
+		},
+		{
+			name: "instance skips names in use",
+			records: []string{
+				"Catalog._x._tcp.local. 120 IN SRV 0 0 80 catalog.local.",
+				`Catalog\ \(2\)._x._tcp.local. 120 IN TXT "a=b"`,
+			},
+			lost:   "Catalog._x._tcp.local.",
+			want:   "Catalog (3)._x._tcp.local.",
+			wantOK: true,
+		},
+		{
+			name:    "service type refused",
+			records: []string{`_x._tcp.local. 120 IN TXT "a=b"`},
+			lost:    "_x._tcp.local.",
+		},
+		{
+			name:    "shared PTR name refused",
+			records: []string{"_x._tcp.local. 120 IN PTR Catalog._x._tcp.local."},
+			lost:    "_x._tcp.local.",
+		},
+		{
+			name:    "name not ours",
+			records: []string{"catalog.local. 120 IN A 10.0.0.1"},
+			lost:    "other.local.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := testConfig(t, tt.records...).nextName(tt.lost)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("nextName(%q) = %q, %v, want %q, %v", tt.lost, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestConfigRenameName(t *testing.T) {
+	records := []string{
+		"catalog.local. 120 IN A 10.0.0.1",
+		"Catalog._x._tcp.local. 120 IN SRV 0 0 80 catalog.local.",
+		`Catalog._x._tcp.local. 120 IN TXT "a=b"`,
+		"_x._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+		"_y._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+	}
+	tests := []struct {
+		name           string
+		tentative      []string
+		oldName        string
+		newName        string
+		wantRecords    []string
+		wantRetargeted []string
+		wantOldPTRs    []string
+	}{
+		{
+			name:    "host retargets SRV",
+			oldName: "catalog.local.",
+			newName: "catalog-2.local.",
+			wantRecords: []string{
+				"catalog-2.local. 120 IN A 10.0.0.1",
+				"Catalog._x._tcp.local. 120 IN SRV 0 0 80 catalog-2.local.",
+				`Catalog._x._tcp.local. 120 IN TXT "a=b"`,
+				"_x._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+				"_y._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+			},
+			wantRetargeted: []string{"Catalog._x._tcp.local."},
+		},
+		{
+			name:      "host with tentative SRV",
+			tentative: []string{"Catalog._x._tcp.local."},
+			oldName:   "catalog.local.",
+			newName:   "catalog-2.local.",
+			wantRecords: []string{
+				"catalog-2.local. 120 IN A 10.0.0.1",
+				"Catalog._x._tcp.local. 120 IN SRV 0 0 80 catalog-2.local.",
+				`Catalog._x._tcp.local. 120 IN TXT "a=b"`,
+				"_x._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+				"_y._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+			},
+		},
+		{
+			name:    "instance retargets PTRs",
+			oldName: "Catalog._x._tcp.local.",
+			newName: "Catalog (2)._x._tcp.local.",
+			wantRecords: []string{
+				"catalog.local. 120 IN A 10.0.0.1",
+				`Catalog\ \(2\)._x._tcp.local. 120 IN SRV 0 0 80 catalog.local.`,
+				`Catalog\ \(2\)._x._tcp.local. 120 IN TXT "a=b"`,
+				`_x._tcp.local. 120 IN PTR Catalog\ \(2\)._x._tcp.local.`,
+				`_y._tcp.local. 120 IN PTR Catalog\ \(2\)._x._tcp.local.`,
+			},
+			wantRetargeted: []string{"_x._tcp.local.", "_y._tcp.local."},
+			wantOldPTRs: []string{
+				"_x._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+				"_y._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+			},
+		},
+		{
+			name:    "name case",
+			oldName: "CATALOG.local.",
+			newName: "CATALOG-2.local.",
+			wantRecords: []string{
+				"CATALOG-2.local. 120 IN A 10.0.0.1",
+				"Catalog._x._tcp.local. 120 IN SRV 0 0 80 CATALOG-2.local.",
+				`Catalog._x._tcp.local. 120 IN TXT "a=b"`,
+				"_x._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+				"_y._tcp.local. 120 IN PTR Catalog._x._tcp.local.",
+			},
+			wantRetargeted: []string{"Catalog._x._tcp.local."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig(t, records...)
+			for _, name := range tt.tentative {
+				c.setTentativeName(name)
+			}
+
+			retargeted, oldPTRs := c.renameName(tt.oldName, tt.newName)
+
+			if got := configRecords(c); !sameRecords(got, mustPlainRRs(t, tt.wantRecords...)) {
+				t.Errorf("records = %v, want %v", got, tt.wantRecords)
+			}
+			if !reflect.DeepEqual(retargeted, append([]string{}, tt.wantRetargeted...)) {
+				t.Errorf("retargeted = %q, want %q", retargeted, tt.wantRetargeted)
+			}
+			if !sameRecords(oldPTRs, mustRRs(t, tt.wantOldPTRs...)) {
+				t.Errorf("old PTRs = %v, want %v", oldPTRs, tt.wantOldPTRs)
+			}
+			if !c.isTentative(tt.newName) || c.isTentative(tt.oldName) {
+				t.Errorf("want %q tentative instead of %q", tt.newName, tt.oldName)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -18,44 +19,66 @@ type Conn struct {
 
 	socket  *ipv4.PacketConn
 	dstAddr *net.UDPAddr
+	ifaces  []net.Interface
 
-	queryInterval time.Duration
-	queries       []query
+	queryInterval    time.Duration
+	maxQueryInterval time.Duration
+	queryLock        sync.Mutex
+	queries          map[string]*querySchedule
 
-	closed chan interface{}
-}
+	lock       sync.Mutex
+	started    bool
+	probes     map[string]*prober
+	announcing map[string]int
+	conflicts  []time.Time
+	held       map[string]*heldQuery
+
+	multicasts *multicastTracker
+	cache      *Cache
+	responder  *responder
+	stats      *Statistics
 
-type query struct {
-	ttype           uint16
-	nameWithSuffix  string
-	queryResultChan chan QueryResult
+	closed chan interface{}
 }
 
 // QueryResult struct used to return the result of a mdns query
 type QueryResult struct {
 	answer []dns.RR
+	extra  []dns.RR
 	addr   net.Addr
 }
 
 type packet struct {
-	buf []byte
-	src net.Addr
-	len int
+	buf     []byte
+	src     net.Addr
+	dst     net.IP
+	len     int
+	ifIndex int
+	ttl     int
 }
 
 const (
-	inboundBufferSize      = 512
-	defaultQueryInterval   = 2 * time.Second
+	inboundBufferSize      = maxPacketSize - ipUDPHeaderSize
+	defaultQueryInterval   = 1 * time.Second
 	destinationAddress     = "224.0.0.251:5353"
 	maxMessageRecords      = 3
 	maxQueryMessageRecords = 1
 	responseTTL            = 10
+	mdnsPort               = 5353
+	onLinkTTL              = 255
+	legacyUnicastTTL       = 10
 )
 
 func (q *QueryResult) GetAnswers() *[]dns.RR {
 	return &q.answer
 }
 
+// GetAdditionals returns the records of the Additional section, like the
+// address of the target of a SRV record
+func (q *QueryResult) GetAdditionals() *[]dns.RR {
+	return &q.extra
+}
+
 func (q *QueryResult) GetAddr() *net.Addr {
 	return &q.addr
 }
@@ -64,17 +87,22 @@ func (q *QueryResult) GetAddr() *net.Addr {
 // to read packets from the multicast group for both client and
 // server side functionality.
 func NewServer(context *context.Context) (*Conn, error) {
-	addr, err := net.ResolveUDPAddr("udp", destinationAddress)
-	if err != nil {
-		return nil, err
-	}
+	return NewServerWithConfig(context, &Config{})
+}
 
-	l, err := net.ListenUDP("udp4", addr)
+// NewServerWithConfig creates a new instance of the mDNS server like
+// NewServer does, using config for records and callbacks. The records in
+// config are probed and announced when the server starts like the ones
+// added with AddARecord and friends
+func NewServerWithConfig(context *context.Context, config *Config) (*Conn, error) {
+	// Bind to any address, not only the group, so we also get the queries
+	// sent straight to us
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: mdnsPort})
 	if err != nil {
 		return nil, err
 	}
 
-	server, err := Server(ipv4.NewPacketConn(l), &Config{})
+	server, err := Server(ipv4.NewPacketConn(l), config)
 	if err != nil {
 		return nil, err
 	}
@@ -82,24 +110,162 @@ func NewServer(context *context.Context) (*Conn, error) {
 	return server, nil
 }
 
-// RemoveARecord removes an A record from the server
+// RemoveARecord removes an A record from the server, peers are told
+// to drop it with a goodbye packet
 func (c *Conn) RemoveARecord(name string) error {
-	return c.config.removeARecord(name)
+	name = addDot(name)
+	announced := c.isStarted() && c.config.isEstablished(name)
+	rec, err := c.config.removeARecord(name)
+	if err != nil {
+		return err
+	}
+	if announced {
+		c.goodbye(func(ip net.IP) []dns.RR {
+			a := rec.A // shallow copy
+			if rec.Dynamic {
+				if ip == nil {
+					return nil
+				}
+				a.A = ip
+			}
+			return []dns.RR{&a}
+		})
+	}
+	return nil
 }
 
-// RemoveSRVRecord remove a srv record from the server
+// RemoveSRVRecord remove a srv record from the server, peers are told
+// to drop it with a goodbye packet
 func (c *Conn) RemoveSRVRecord(name string) error {
-	return c.config.removeSRVRecord(name)
+	name = addDot(name)
+	announced := c.isStarted() && c.config.isEstablished(name)
+	rec, err := c.config.removeSRVRecord(name)
+	if err != nil {
+		return err
+	}
+	if announced {
+		c.goodbye(func(ip net.IP) []dns.RR {
+			srv := *rec
+			return []dns.RR{&srv}
+		})
+	}
+	return nil
 }
 
-// AddARecord add an A record to the server
+// AddARecord add an A record to the server, if the server is running
+// it blocks while the name is probed. When another host already owns the
+// name the record is renamed, "name-2.local." and so on, probed again and
+// reported through Config.RenameCallback, ErrNameConflict is only returned
+// if the name cannot be renamed. Records added before Start are probed
+// when the server starts and reported through Config.ProbeCallback
 func (c *Conn) AddARecord(name string, dst *net.IP, dyn bool) error {
-	return c.config.addARecord(name, dst, dyn)
+	if err := c.config.addARecord(name, dst, dyn); err != nil {
+		return err
+	}
+	if !c.isStarted() {
+		return nil
+	}
+	return c.probeName(addDot(name))
 }
 
-// AddSRVRecord add an SRV record to the server
+// AddSRVRecord add an SRV record to the server, probing the name like
+// AddARecord does
 func (c *Conn) AddSRVRecord(name string, priority, weight, port uint16, target string) error {
-	return c.config.addSRVRecord(name, priority, weight, port, target)
+	if err := c.config.addSRVRecord(name, priority, weight, port, target); err != nil {
+		return err
+	}
+	if !c.isStarted() {
+		return nil
+	}
+	return c.probeName(addDot(name))
+}
+
+// UpdateARecord changes the address of an A record and announces the
+// new data to the network
+func (c *Conn) UpdateARecord(name string, dst *net.IP, dyn bool) error {
+	if err := c.config.updateARecord(name, dst, dyn); err != nil {
+		return err
+	}
+	if c.isStarted() {
+		go c.announce(addDot(name))
+	}
+	return nil
+}
+
+// UpdateSRVRecord changes the data of a SRV record and announces the
+// new data to the network
+func (c *Conn) UpdateSRVRecord(name string, priority, weight, port uint16, target string) error {
+	if err := c.config.updateSRVRecord(name, priority, weight, port, target); err != nil {
+		return err
+	}
+	if c.isStarted() {
+		go c.announce(addDot(name))
+	}
+	return nil
+}
+
+// AddTXTRecord add a TXT record to the server, probing the name like
+// AddARecord does
+func (c *Conn) AddTXTRecord(name string, txt []string) error {
+	if err := c.config.addTXTRecord(name, txt); err != nil {
+		return err
+	}
+	if !c.isStarted() {
+		return nil
+	}
+	return c.probeName(addDot(name))
+}
+
+// RemoveTXTRecord remove a txt record from the server, peers are told
+// to drop it with a goodbye packet
+func (c *Conn) RemoveTXTRecord(name string) error {
+	name = addDot(name)
+	announced := c.isStarted() && c.config.isEstablished(name)
+	rec, err := c.config.removeTXTRecord(name)
+	if err != nil {
+		return err
+	}
+	if announced {
+		c.goodbye(func(ip net.IP) []dns.RR {
+			txt := *rec
+			return []dns.RR{&txt}
+		})
+	}
+	return nil
+}
+
+// AddPTRRecord add a PTR record to the server, PTR records are shared
+// so they are announced without probing
+func (c *Conn) AddPTRRecord(name, target string) error {
+	if err := c.config.addPTRRecord(name, target); err != nil {
+		return err
+	}
+	if c.isStarted() {
+		go c.announce(addDot(name))
+	}
+	return nil
+}
+
+// RemovePTRRecord removes a PTR record from the server, peers are told
+// to drop it with a goodbye packet
+func (c *Conn) RemovePTRRecord(name, target string) error {
+	rec, err := c.config.removePTRRecord(name, target)
+	if err != nil {
+		return err
+	}
+	if c.isStarted() {
+		c.goodbye(func(ip net.IP) []dns.RR {
+			ptr := *rec
+			return []dns.RR{&ptr}
+		})
+	}
+	return nil
+}
+
+func (c *Conn) isStarted() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.started
 }
 
 // Server establishes a mDNS connection over an existing conn
@@ -107,38 +273,68 @@ func Server(conn *ipv4.PacketConn, config *Config) (*Conn, error) {
 	if config == nil {
 		return nil, errNilConfig
 	}
+	// The records already in the config are not ours until probed
+	config.setConfiguredTentative()
 
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return nil, err
 	}
 
-	joinErrCount := 0
+	joined := make([]net.Interface, 0, len(ifaces))
 	for i := range ifaces {
-		if err = conn.JoinGroup(&ifaces[i], &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251)}); err != nil {
-			joinErrCount++
+		if err = conn.JoinGroup(&ifaces[i], &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251)}); err == nil {
+			joined = append(joined, ifaces[i])
 		}
 	}
-	if joinErrCount >= len(ifaces) {
+	if len(joined) == 0 {
 		return nil, errJoiningMulticastGroup
 	}
 
+	// We need to know the interface packets arrive on to probe and
+	// answer with the right address, their destination to tell unicast
+	// queries apart and their TTL to reject packets from off-link
+	if err := conn.SetControlMessage(ipv4.FlagInterface|ipv4.FlagDst|ipv4.FlagSrc|ipv4.FlagTTL, true); err != nil {
+		Log().Debug("Failed to enable control messages", zap.Error(err))
+	}
+
+	// Receivers use a TTL of 255 to know our packets come from on-link
+	if err := conn.SetMulticastTTL(onLinkTTL); err != nil {
+		Log().Debug("Failed to set multicast TTL", zap.Error(err))
+	}
+	if err := conn.SetTTL(onLinkTTL); err != nil {
+		Log().Debug("Failed to set TTL", zap.Error(err))
+	}
+
 	dstAddr, err := net.ResolveUDPAddr("udp", destinationAddress)
 	if err != nil {
 		return nil, err
 	}
 
 	c := &Conn{
-		queryInterval: defaultQueryInterval,
-		queries:       []query{},
-		socket:        conn,
-		dstAddr:       dstAddr,
-		config:        config,
-		closed:        make(chan interface{}),
+		ctx:              context.Background(),
+		queryInterval:    defaultQueryInterval,
+		maxQueryInterval: defaultMaxQueryInterval,
+		queries:          make(map[string]*querySchedule),
+		socket:           conn,
+		dstAddr:          dstAddr,
+		ifaces:           joined,
+		config:           config,
+		probes:           make(map[string]*prober),
+		announcing:       make(map[string]int),
+		held:             make(map[string]*heldQuery),
+		multicasts:       newMulticastTracker(),
+		cache:            newCache(),
+		responder:        newResponder(),
+		stats:            &Statistics{},
+		closed:           make(chan interface{}),
 	}
 	if config.QueryInterval != 0 {
 		c.queryInterval = config.QueryInterval
 	}
+	if config.MaxQueryInterval != 0 {
+		c.maxQueryInterval = config.MaxQueryInterval
+	}
 
 	return c, nil
 }
@@ -148,6 +344,10 @@ func (c *Conn) Start() { //nolint gocognit
 	var wg sync.WaitGroup
 
 	Log().Info("Starting mdns server")
+	c.lock.Lock()
+	c.started = true
+	c.lock.Unlock()
+
 	queue := make(chan packet) // Check this, channel of slice issues
 
 	// Goroutine to read a packet and push it to the channel
@@ -155,15 +355,32 @@ func (c *Conn) Start() { //nolint gocognit
 	wg.Add(1)
 	go func(wg *sync.WaitGroup) {
 		defer wg.Done()
-		b := make([]byte, inboundBufferSize)
+		// One byte more than the largest packet allowed tells us when a
+		// packet is too big
+		b := make([]byte, inboundBufferSize+1)
 		// Read packet from Socket
 		for {
-			n, _, src, err := c.socket.ReadFrom(b)
+			n, cm, src, err := c.socket.ReadFrom(b)
 			if err != nil { // Exit if socket error
 				return
 			}
+			if n > inboundBufferSize {
+				atomic.AddUint64(&c.stats.OversizeDrops, 1)
+				Log().Debug("Dropping oversize packet", zap.String("source", src.String()))
+				continue
+			}
 			if n > 0 {
-				queue <- packet{buf: b[:n], len: n, src: src}
+				// The buffer is reused for the next read, the packet
+				// needs its own copy
+				buf := make([]byte, n)
+				copy(buf, b[:n])
+				p := packet{buf: buf, len: n, src: src}
+				if cm != nil {
+					p.ifIndex = cm.IfIndex
+					p.dst = cm.Dst
+					p.ttl = cm.TTL
+				}
+				queue <- p
 			}
 		}
 	}(&wg)
@@ -176,6 +393,10 @@ func (c *Conn) Start() { //nolint gocognit
 		for {
 			select {
 			case <-c.ctx.Done():
+				// Tell peers to forget about us before we go
+				c.goodbye(func(ip net.IP) []dns.RR {
+					return append(c.config.establishedRecords(ip), c.config.sharedRecords()...)
+				})
 				close(c.closed)
 				c.socket.Close()
 				return
@@ -208,76 +429,189 @@ func (c *Conn) Start() { //nolint gocognit
 					continue
 				}
 
-				//    In query messages, if the TC bit is set, it means that additional
-				//    Known-Answer records may be following shortly.  A responder SHOULD
-				//    record this fact, and wait for those additional Known-Answer records,
-				//    before deciding whether to respond.  If the TC bit is clear, it means
-				//    that the querying host has no additional Known Answers.
-				if msg.Truncated {
-					Log().Debug("support for DNS requests with high truncated bit not implemented", zap.Error(errInvalidPacket))
+				// "Multicast DNS implementations MUST silently ignore any Multicast DNS
+				// responses they receive where the source UDP port is not 5353", RFC 6762
+				// section 6
+				if msg.Response && isLegacyQuerier(p.src) {
+					Log().Debug("Dropping response not sent from the mDNS port", zap.String("source", p.src.String()))
 					continue
 				}
 
-				c.processQuestions(msg, p.src)
-				c.processAnswers(msg, p.src)
+				// RFC 6762 section 11, only queries sent to us by unicast
+				// may come from off-link, and only if allowed
+				if !c.isOnLink(p) && (msg.Response || !p.isUnicast() || !c.config.AllowOffLinkUnicast) {
+					atomic.AddUint64(&c.stats.OffLink, 1)
+					Log().Debug("Dropping packet from off-link", zap.String("source", p.src.String()))
+					continue
+				}
+
+				if msg.Response {
+					c.checkProbeConflicts(msg, p)
+					c.checkConflicts(msg, p)
+					c.suppressDuplicateAnswers(msg, p)
+					c.processAnswers(msg, p)
+				} else {
+					c.noteQuestions(msg, p)
+					if !c.holdQuery(msg, p) {
+						c.processProbes(msg, p)
+						c.processQuestions(msg, p)
+					}
+				}
 			}
 		}
 	}(&wg)
+
+	// Claim the names added before we started
+	c.probeTentative()
+	c.announceShared()
+
 	// We block here
 	wg.Wait()
 	Log().Debug("Stop mdns server")
 }
 
-func (c *Conn) processQuestions(msg dns.Msg, src net.Addr) {
+func (c *Conn) processQuestions(msg dns.Msg, p packet) {
+	// "When a Multicast DNS responder receives a query via direct unicast,
+	// it SHOULD respond as it would for "QU" questions", queriers off-link
+	// can only be reached by unicast
+	unicastQuery := p.isUnicast()
+	offSubnet := unicastQuery && !c.isOnLink(p)
+	legacy := isLegacyQuerier(p.src)
+
+	// The answers to all the questions of the message are sent together
+	multicast := make([]dns.RR, 0)
+	multicastExtra := make([]dns.RR, 0)
+	unicast := make([]dns.RR, 0)
+	unicastExtra := make([]dns.RR, 0)
+	answered := make([]dns.Question, 0, len(msg.Question))
+	// Dynamic A records get the address we probed and announced with on
+	// the interface, so our answers match the records we own
+	ip := c.localIP(p.ifIndex, p.src)
+
 	// Process questions if any
 	for _, q := range msg.Question {
 		answers := make([]dns.RR, 0)
+		extra := make([]dns.RR, 0)
+		interval := multicastInterval
+
+		if len(msg.Ns) > 0 && q.Qtype == dns.TypeANY && c.config.isEstablished(q.Name) {
+			// Somebody is probing for a name we own, defend it
+			answers = c.config.recordsFor(q.Name, ip)
+			interval = probeDefenseInterval
+		} else if err := c.config.LookupRecords(&answers, &extra, &q, ip); err != nil {
+			continue
+		}
+
+		// A name we own without the type asked for gets a NSEC record so
+		// the querier can stop asking, RFC 6762 section 6.1
+		if len(answers) == 0 {
+			if nsec := c.config.lookupNSEC(q.Name); nsec != nil {
+				answers = append(answers, nsec)
+			}
+		}
+
+		answers = c.suppressKnownAnswers(answers, msg.Answer)
+		extra = c.suppressKnownAnswers(extra, msg.Answer)
+		if len(answers) == 0 {
+			continue
+		}
+		if legacy {
+			answered = append(answered, q)
+			unicast = appendRecords(unicast, answers...)
+			unicastExtra = appendRecords(unicastExtra, extra...)
+			continue
+		}
+		if q.Qclass&qclassUnicastResponse != 0 || unicastQuery {
+			direct := answers
+			if offSubnet {
+				answers = nil
+			} else {
+				direct, answers = c.splitUnicast(answers, p.ifIndex)
+			}
+			if len(direct) > 0 {
+				unicast = appendRecords(unicast, direct...)
+				unicastExtra = appendRecords(unicastExtra, extra...)
+			}
+		}
+		answers = c.rateLimit(answers, p.ifIndex, interval)
+		if len(answers) > 0 {
+			multicast = appendRecords(multicast, answers...)
+			multicastExtra = appendRecords(multicastExtra, extra...)
+		}
+	}
 
-		if err := c.config.Lookup(&answers, &q, src); err == nil {
-			msg := createAnswerMessage(&msg, &answers)
-			c.sendAnswer(msg, src)
+	if len(unicast) > 0 {
+		extra := c.additionalRecords(unicast, unicastExtra)
+		if legacy {
+			c.sendUnicast(createLegacyAnswerMessage(&msg, answered, unicast, extra, c.messageSize(p.ifIndex)), p)
+		} else {
+			c.sendUnicast(createAnswerMessage(&dns.Msg{}, &unicast, &extra), p)
 		}
 	}
+	if len(multicast) > 0 {
+		extra := c.rateLimit(c.additionalRecords(multicast, multicastExtra), p.ifIndex, multicastInterval)
+		c.scheduleResponse(p.ifIndex, multicast, extra, responseDelay(multicast))
+	}
 }
 
-func (c *Conn) processAnswers(msg dns.Msg, src net.Addr) {
+func (c *Conn) processAnswers(msg dns.Msg, p packet) {
+	// The OPT pseudo record describes the message, it is not an answer
+	msg.Extra = withoutOPT(msg.Extra)
+
+	for _, rr := range msg.Answer {
+		c.cache.add(rr, p)
+	}
+	for _, rr := range msg.Extra {
+		c.cache.add(rr, p)
+	}
+
 	// Process answers if any
-	for _, a := range msg.Answer {
-		switch rr := a.(type) {
-		case *dns.A:
-			// TODO: Query lock
-			for i := len(c.queries) - 1; i >= 0; i-- {
-				if c.queries[i].nameWithSuffix == rr.Header().Name && c.queries[i].ttype == rr.Header().Rrtype {
-					// send respond back to client
-					c.queries[i].queryResultChan <- QueryResult{msg.Answer, src}
-					// Remove query, we already have a response
-					c.queries = append(c.queries[:i], c.queries[i+1:]...)
-				}
+	answered := make(map[string]bool)
+	for _, rr := range msg.Answer {
+		h := rr.Header()
+		// A goodbye record withdraws an answer, it does not answer anything
+		if h.Ttl == 0 {
+			continue
+		}
+		switch rr.(type) {
+		case *dns.A, *dns.SRV, *dns.TXT, *dns.PTR:
+			key := queryKey(h.Name, h.Rrtype)
+			if answered[key] {
+				continue
 			}
-		case *dns.SRV:
-			for i := len(c.queries) - 1; i >= 0; i-- {
-				if c.queries[i].nameWithSuffix == rr.Header().Name && c.queries[i].ttype == rr.Header().Rrtype {
-					// send respond back to client
-					c.queries[i].queryResultChan <- QueryResult{msg.Answer, src}
-					// Remove query, we already have a response
-					c.queries = append(c.queries[:i], c.queries[i+1:]...)
-				}
+			answered[key] = true
+
+			// send respond back to the clients waiting, they have a response
+			answers := c.cache.answers(h.Name, h.Rrtype)
+			if len(answers) == 0 {
+				continue
 			}
+			c.answerQuery(h.Name, h.Rrtype, QueryResult{answers, c.cache.additionals(answers), p.src}, maxTTL(answers))
 		}
 	}
-
 }
 
-func (c *Conn) sendAnswer(msg *dns.Msg, src net.Addr) {
-	rawAnswer, err := msg.Pack()
-	if err != nil {
-		Log().Debug("Failed to construct mDNS packet", zap.Error(err))
-		return
-	}
+// sendAnswer multicasts a response on an interface and records when its
+// records were sent for rate limiting
+func (c *Conn) sendAnswer(msg *dns.Msg, ifIndex int) {
+	for _, m := range splitResponse(msg, c.messageSize(ifIndex)) {
+		rawAnswer, err := m.Pack()
+		if err != nil {
+			Log().Debug("Failed to construct mDNS packet", zap.Error(err))
+			continue
+		}
+
+		if err := c.writeTo(rawAnswer, ifIndex, c.dstAddr); err != nil {
+			Log().Debug("Failed to send mDNS packet", zap.Error(err))
+			continue
+		}
 
-	if _, err := c.socket.WriteTo(rawAnswer, nil, c.dstAddr); err != nil {
-		Log().Debug("Failed to send mDNS packet", zap.Error(err))
-		return
+		for _, rr := range m.Answer {
+			c.multicasts.sent(ifIndex, rr)
+		}
+		for _, rr := range m.Extra {
+			c.multicasts.sent(ifIndex, rr)
+		}
 	}
 }
 
@@ -295,105 +629,295 @@ func (c *Conn) QuerySync(ctx context.Context, name string, ttype uint16) (*Query
 
 	name = addDot(name)
 
-	queryChan := make(chan QueryResult, 1)
-
-	c.queries = append(c.queries,
-		query{ttype: ttype,
-			nameWithSuffix:  name,
-			queryResultChan: queryChan})
+	// Answers still fresh in the cache need no question on the wire
+	if cached := c.cache.lookup(name, ttype, true); len(cached) > 0 {
+		answers := entryRecords(cached)
+		return &QueryResult{answers, c.cache.additionals(answers), cached[0].Src}, nil
+	}
 
-	ticker := time.NewTicker(c.queryInterval)
+	results := c.addQuery(name, ttype, false)
+	defer c.removeQuery(name, ttype, results)
 
-	c.sendQuestion(name, ttype)
 	// Block Here
-	for {
-		select {
-		case <-ticker.C:
-			c.sendQuestion(name, ttype)
-		case <-c.closed:
-			return nil, errConnectionClosed
-		case res := <-queryChan:
-			return &res, nil
-		case <-ctx.Done():
-			return nil, errContextElapsed
-		}
+	select {
+	case <-c.closed:
+		return nil, errConnectionClosed
+	case res := <-results:
+		return &res, nil
+	case <-ctx.Done():
+		return nil, errContextElapsed
 	}
 }
 
 // QueryASync sends mDNS Queries for the following name until
 // either the Context is canceled/expires or we get a result
 // Query will add the ending dot to the query name
-// TODO: Mutex lock the queries structure, for multiple queries at the same time
 func (c *Conn) QueryASync(ctx context.Context, name string, ttype uint16) chan *QueryResult {
 	results := make(chan *QueryResult)
 	go func() {
-		// The multicast process close the connection, we cannot query
-		select {
-		case <-c.closed:
+		res, err := c.QuerySync(ctx, name, ttype)
+		if err != nil {
 			// Close channel so other end knows that there was an error
-			Log().Debug("Connection close", zap.Error(errConnectionClosed))
+			Log().Debug("Query failed", zap.Error(err))
 			close(results)
 			return
-		default:
 		}
+		// mdns process returned a response, return to our client
+		results <- res
+	}()
 
-		name = addDot(name)
-		// Create a query channel with the mdns process
-		queryChan := make(chan QueryResult, 1)
+	return results
+}
 
-		c.queries = append(c.queries,
-			query{ttype: ttype,
-				nameWithSuffix:  name,
-				queryResultChan: queryChan})
+// QueryContinuous sends mDNS Queries for the following name until the
+// Context is canceled/expires, the fresh answers in the cache and every
+// answer received after that are sent on the returned channel, which is
+// closed when the query ends. The answers we hold go in the Known-Answer
+// section of the Queries, so responders only send us what is new
+func (c *Conn) QueryContinuous(ctx context.Context, name string, ttype uint16) chan *QueryResult {
+	results := make(chan *QueryResult)
+	name = addDot(name)
+	go func() {
+		defer close(results)
+		// The multicast process close the connection, we cannot query
+		select {
+		case <-c.closed:
+			return
+		default:
+		}
 
-		ticker := time.NewTicker(c.queryInterval)
+		answers := c.addQuery(name, ttype, true)
+		defer c.removeQuery(name, ttype, answers)
 
-		c.sendQuestion(name, ttype)
-		// Block Here
+		pending := make([]*QueryResult, 0)
+		if cached := c.cache.lookup(name, ttype, true); len(cached) > 0 {
+			records := entryRecords(cached)
+			pending = append(pending, &QueryResult{records, c.cache.additionals(records), cached[0].Src})
+		}
 		for {
+			// Only offer a result to our client when we have one
+			var out chan *QueryResult
+			var next *QueryResult
+			if len(pending) > 0 {
+				out, next = results, pending[0]
+			}
 			select {
-			// Time expired , send question to the network again
-			case <-ticker.C:
-				c.sendQuestion(name, ttype)
-			// The connection close, we cannot query
+			case out <- next:
+				pending = pending[1:]
+			case res := <-answers:
+				pending = append(pending, &res)
 			case <-c.closed:
-				Log().Debug("Connection close", zap.Error(errConnectionClosed))
-				close(results)
-				return
-			// mdns process returned a response, return to our client
-			case res := <-queryChan:
-				results <- &res
 				return
 			case <-ctx.Done():
-				Log().Debug("Context cancel or timeout", zap.Error(errConnectionClosed))
-				close(results)
 				return
 			}
 		}
 	}()
 
 	return results
+}
 
+// writeTo sends b out of the interface with index ifIndex, zero lets
+// the kernel pick the interface
+func (c *Conn) writeTo(b []byte, ifIndex int, dst net.Addr) error {
+	var cm *ipv4.ControlMessage
+	if ifIndex != 0 {
+		cm = &ipv4.ControlMessage{IfIndex: ifIndex}
+	}
+	_, err := c.socket.WriteTo(b, cm, dst)
+	return err
+}
+
+// interfaceByIndex returns one of the interfaces we joined or nil
+func (c *Conn) interfaceByIndex(ifIndex int) *net.Interface {
+	for i := range c.ifaces {
+		if c.ifaces[i].Index == ifIndex {
+			return &c.ifaces[i]
+		}
+	}
+	return nil
+}
+
+// isUnicast returns true if the packet was sent to our address instead
+// of the multicast group
+func (p *packet) isUnicast() bool {
+	return p.dst != nil && !p.dst.IsMulticast()
+}
+
+// isOnLink returns true if a packet comes from a directly connected
+// subnet, either its IP TTL is 255 so it was not routed or its source is
+// on the subnet of the interface it came in from
+func (c *Conn) isOnLink(p packet) bool {
+	if p.ttl == onLinkTTL {
+		return true
+	}
+	addr, ok := p.src.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	if ifi := c.interfaceByIndex(p.ifIndex); ifi != nil {
+		return interfaceContains(ifi, addr.IP)
+	}
+	for i := range c.ifaces {
+		if interfaceContains(&c.ifaces[i], addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// localIP returns our address on the interface a packet came in from,
+// falling back to the address we use to reach src
+func (c *Conn) localIP(ifIndex int, src net.Addr) net.IP {
+	if ifi := c.interfaceByIndex(ifIndex); ifi != nil {
+		if ip := interfaceIPv4(ifi); ip != nil {
+			return ip
+		}
+	}
+	if ip, err := interfaceForRemote(src.String()); err == nil {
+		return ip
+	}
+	return nil
+}
+
+// isOwnPacket returns true if p was sent by us, from the mDNS port of one
+// of our addresses, our multicast packets loop back to us
+func (c *Conn) isOwnPacket(p packet) bool {
+	addr, ok := p.src.(*net.UDPAddr)
+	if !ok || addr.Port != mdnsPort {
+		return false
+	}
+	for i := range c.ifaces {
+		if interfaceHasIP(&c.ifaces[i], addr.IP) {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *Conn) sendQuestion(name string, ttype uint16) {
 	msg := new(dns.Msg)
 	msg.SetQuestion(name, ttype)
+	// "In multicast query messages, the Query Identifier SHOULD be set to
+	// zero on transmission", RFC 6762 section 18.1
+	msg.Id = 0
 	msg.RecursionDesired = true
+	msg.Compress = true
+	// The answers we hold already do not need to be sent again
+	msg.Answer = c.cache.knownAnswers(name, ttype)
+	// Tell unicast responders how big a response we can take
+	msg.SetEdns0(inboundBufferSize, false)
+
+	for _, m := range splitQuery(msg, c.queryMessageSize()) {
+		rawQuery, err := m.Pack()
+		if err != nil {
+			Log().Debug("Failed to construct mDNS packet", zap.Error(err))
+			return
+		}
 
-	rawQuery, err := msg.Pack()
-	if err != nil {
-		Log().Debug("Failed to construct mDNS packet", zap.Error(err))
-		return
+		if _, err := c.socket.WriteTo(rawQuery, nil, c.dstAddr); err != nil {
+			Log().Debug("Failed to send mDNS packet", zap.Error(err))
+			return
+		}
+	}
+}
+
+// splitQuery splits a query whose Known-Answer list does not fit in size
+// bytes, RFC 6762 section 7.2, the questions go in the first packet and
+// every packet but the last has the TC bit set so responders wait for
+// the rest of the known answers
+func splitQuery(msg *dns.Msg, size int) []*dns.Msg {
+	if msg.Len() <= size {
+		return []*dns.Msg{msg}
+	}
+
+	msgs := make([]*dns.Msg, 0)
+	next := func() *dns.Msg {
+		m := msg.Copy()
+		m.Answer = nil
+		if len(msgs) > 0 {
+			m.Question, m.Ns = nil, nil
+			msgs[len(msgs)-1].Truncated = true
+		}
+		msgs = append(msgs, m)
+		return m
+	}
+
+	m := next()
+	for _, rr := range msg.Answer {
+		m.Answer = append(m.Answer, rr)
+		if len(m.Answer) > 1 && m.Len() > size {
+			m.Answer = m.Answer[:len(m.Answer)-1]
+			m = next()
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	return msgs
+}
+
+// isLegacyQuerier returns true if a query did not come from the mDNS port,
+// the querier is a simple resolver expecting a conventional unicast reply
+func isLegacyQuerier(src net.Addr) bool {
+	addr, ok := src.(*net.UDPAddr)
+	return ok && addr.Port != mdnsPort
+}
+
+// createLegacyAnswerMessage builds a legacy unicast response, RFC 6762
+// section 6.7, the query ID and the questions answered are echoed, TTLs
+// are capped at ten seconds and the cache-flush bit is not used. The
+// response is truncated to maxSize bytes at most
+func createLegacyAnswerMessage(q *dns.Msg, questions []dns.Question, answers, extra []dns.RR, maxSize int) *dns.Msg {
+	msg := createAnswerMessage(q, &answers, &extra)
+	for _, rr := range append(append([]dns.RR{}, msg.Answer...), msg.Extra...) {
+		rr.Header().Class &^= rrclassCacheFlush
+		if rr.Header().Ttl > legacyUnicastTTL {
+			rr.Header().Ttl = legacyUnicastTTL
+		}
+	}
+
+	msg.Question = make([]dns.Question, 0, len(questions))
+	for _, question := range questions {
+		question.Qclass &^= qclassUnicastResponse
+		msg.Question = append(msg.Question, question)
 	}
 
-	if _, err := c.socket.WriteTo(rawQuery, nil, c.dstAddr); err != nil {
-		Log().Debug("Failed to send mDNS packet", zap.Error(err))
-		return
+	// Legacy resolvers only take what fits their buffer, the TC bit tells
+	// them the answer is incomplete. A query with an EDNS0 OPT record
+	// gets one back, RFC 6891 section 6.1.1
+	size := dns.MinMsgSize
+	if opt := q.IsEdns0(); opt != nil {
+		size = int(opt.UDPSize())
+		msg.SetEdns0(inboundBufferSize, false)
+	}
+	if size > maxSize {
+		size = maxSize
 	}
+	msg.Truncate(size)
+	return msg
 }
 
-func createAnswerMessage(q *dns.Msg, answer *[]dns.RR) *dns.Msg {
+// withCacheFlush returns copies of records where the unique ones get the
+// cache-flush bit, RFC 6762 section 10.2
+func withCacheFlush(records []dns.RR) []dns.RR {
+	flushed := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		rr = dns.Copy(rr)
+		if !isShared(rr) {
+			rr.Header().Class |= rrclassCacheFlush
+		}
+		flushed = append(flushed, rr)
+	}
+	return flushed
+}
+
+// createAnswerMessage builds a response with copies of the answers and
+// additional records, extra may be nil
+func createAnswerMessage(q *dns.Msg, answer *[]dns.RR, extra *[]dns.RR) *dns.Msg {
+	var additionals []dns.RR
+	if extra != nil && len(*extra) > 0 {
+		additionals = withCacheFlush(*extra)
+	}
+
 	return &dns.Msg{
 		MsgHdr: dns.MsgHdr{
 			Id:            q.Id,
@@ -403,7 +927,8 @@ func createAnswerMessage(q *dns.Msg, answer *[]dns.RR) *dns.Msg {
 		},
 		Compress: true,
 
-		Answer: *answer,
+		Answer: withCacheFlush(*answer),
+		Extra:  additionals,
 	}
 
 }
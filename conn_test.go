@@ -0,0 +1,134 @@
+package mdns
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func hostRecords(t *testing.T, n int, ttl uint32, class string) []dns.RR {
+	t.Helper()
+	rrs := make([]dns.RR, 0, n)
+	for i := 0; i < n; i++ {
+		rrs = append(rrs, mustRR(t, fmt.Sprintf("host%d.local. %d %s A 10.0.%d.%d", i, ttl, class, i/256, i%256)))
+	}
+	return rrs
+}
+
+func TestCreateLegacyAnswerMessage(t *testing.T) {
+	tests := []struct {
+		name          string
+		answers       int
+		ttl           uint32
+		udpSize       uint16
+		maxSize       int
+		wantTTL       uint32
+		wantTruncated bool
+		wantSize      int
+	}{
+		{"TTL capped", 3, 120, 0, 1472, legacyUnicastTTL, false, dns.MinMsgSize},
+		{"short TTL kept", 3, 5, 0, 1472, 5, false, dns.MinMsgSize},
+		{"truncated to 512 bytes", 100, 120, 0, 1472, legacyUnicastTTL, true, dns.MinMsgSize},
+		{"EDNS0 buffer size", 100, 120, 4096, inboundBufferSize, legacyUnicastTTL, false, 4096},
+		{"EDNS0 capped by the interface", 100, 120, 4096, 1472, legacyUnicastTTL, true, 1472},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := new(dns.Msg)
+			q.Id = 0x1234
+			q.Question = []dns.Question{{Name: "host0.local.", Qtype: dns.TypeA, Qclass: dns.ClassINET | qclassUnicastResponse}}
+			if tt.udpSize != 0 {
+				q.SetEdns0(tt.udpSize, false)
+			}
+			answers := hostRecords(t, tt.answers, tt.ttl, "CLASS32769")
+
+			msg := createLegacyAnswerMessage(q, q.Question, answers, nil, tt.maxSize)
+
+			if msg.Id != q.Id {
+				t.Errorf("Id = %#x, want %#x", msg.Id, q.Id)
+			}
+			if len(msg.Question) != 1 || msg.Question[0].Qclass != dns.ClassINET {
+				t.Errorf("Question = %v, want the question without the QU bit", msg.Question)
+			}
+			if msg.Truncated != tt.wantTruncated {
+				t.Errorf("Truncated = %v, want %v", msg.Truncated, tt.wantTruncated)
+			}
+			if tt.wantTruncated && len(msg.Answer) >= tt.answers {
+				t.Errorf("got %d answers, want fewer than %d", len(msg.Answer), tt.answers)
+			}
+			if !tt.wantTruncated && len(msg.Answer) != tt.answers {
+				t.Errorf("got %d answers, want %d", len(msg.Answer), tt.answers)
+			}
+			if l := msg.Len(); l > tt.wantSize {
+				t.Errorf("Len() = %d, want at most %d", l, tt.wantSize)
+			}
+			if (msg.IsEdns0() != nil) != (tt.udpSize != 0) {
+				t.Errorf("IsEdns0() = %v, want an OPT record only if the query had one", msg.IsEdns0())
+			}
+			for _, rr := range msg.Answer {
+				if rr.Header().Class != dns.ClassINET {
+					t.Errorf("%v: class %d, want the cache-flush bit cleared", rr, rr.Header().Class)
+				}
+				if rr.Header().Ttl != tt.wantTTL {
+					t.Errorf("%v: TTL %d, want %d", rr, rr.Header().Ttl, tt.wantTTL)
+				}
+			}
+			for _, rr := range answers {
+				if rr.Header().Ttl != tt.ttl {
+					t.Errorf("%v: the records passed in were modified", rr)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		known    int
+		size     int
+		wantMsgs int
+	}{
+		{"no known answers", 0, 1472, 1},
+		{"known answers fit", 20, 1472, 1},
+		{"known answers split", 200, 1472, 4},
+		{"jumbo frame", 200, 8972, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := new(dns.Msg)
+			msg.Compress = true
+			msg.Question = []dns.Question{{Name: "_http._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET}}
+			msg.Answer = hostRecords(t, tt.known, 120, "IN")
+			msg.SetEdns0(inboundBufferSize, false)
+
+			msgs := splitQuery(msg, tt.size)
+
+			if len(msgs) != tt.wantMsgs {
+				t.Errorf("got %d messages, want %d", len(msgs), tt.wantMsgs)
+			}
+			var known []dns.RR
+			for i, m := range msgs {
+				if l := m.Len(); l > tt.size {
+					t.Errorf("message %d: Len() = %d, want at most %d", i, l, tt.size)
+				}
+				if last := i == len(msgs)-1; m.Truncated == last {
+					t.Errorf("message %d: Truncated = %v, want the TC bit on all but the last", i, m.Truncated)
+				}
+				if wantQuestions := i == 0; (len(m.Question) > 0) != wantQuestions {
+					t.Errorf("message %d: Question = %v, want the question in the first message only", i, m.Question)
+				}
+				if m.IsEdns0() == nil {
+					t.Errorf("message %d: OPT record missing", i)
+				}
+				known = append(known, m.Answer...)
+			}
+			if !sameRecords(known, msg.Answer) {
+				t.Errorf("known answers not kept whole and in order")
+			}
+		})
+	}
+}
@@ -115,9 +115,10 @@ func (d *Discovery) FindCatalog(opts ...func(*DiscoverySrvQuery)) chan *Discover
 				close(discoverResults)
 				return
 			}
-			answers := res.GetAnswers()
+			// The address of the SRV target is in the Additional section
+			answers := append(*res.GetAnswers(), *res.GetAdditionals()...)
 			dr := &DiscoverySrvResult{}
-			for _, a := range *answers {
+			for _, a := range answers {
 				if rr, ok := a.(*dns.A); ok {
 					dr.Addr = &rr.A
 				}
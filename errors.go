@@ -12,3 +12,7 @@ var (
 	errInvalidParameter      = errors.New("mDNS: invalid parameter")
 	errInvalidPacket         = errors.New("mDNS: invalid packet")
 )
+
+// ErrNameConflict is returned and passed to Config.ProbeCallback when
+// another host owns a name we probed for
+var ErrNameConflict = errors.New("mDNS: name already in use on the network")
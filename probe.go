@@ -0,0 +1,384 @@
+package mdns
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	probeCount      = 3
+	probeInterval   = 250 * time.Millisecond
+	probeDeferDelay = 1 * time.Second
+
+	maxConflicts       = 15
+	conflictWindow     = 10 * time.Second
+	conflictProbeDelay = 5 * time.Second
+
+	// qclassUnicastResponse is the top bit of the question class, it asks
+	// for a unicast response (QU question)
+	qclassUnicastResponse = 1 << 15
+	// rrclassCacheFlush is the top bit of the record class, it marks
+	// unique records whose old rdata must be flushed from caches
+	rrclassCacheFlush = 1 << 15
+)
+
+// prober tracks a name being probed, the mdns process signals it when
+// it sees packets that matter for the probe
+type prober struct {
+	name string
+	// lost is signaled when another host answers for the name
+	lost chan struct{}
+	// deferred is signaled when we lose a simultaneous probe tie-break
+	deferred chan struct{}
+}
+
+// signal does a non blocking send on a prober channel
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// probeName probes for a name and marks it ours if nobody else uses it.
+// When the probe is lost the records are renamed and probed again, if
+// the name cannot be renamed the records are removed and ErrNameConflict
+// is returned
+func (c *Conn) probeName(name string) error {
+	original := name
+	renamedTargets := make([]string, 0)
+
+	for {
+		err := c.probe(name)
+		if err == ErrNameConflict {
+			c.noteConflict()
+			newName, ok := c.config.nextName(name)
+			if ok {
+				Log().Debug("Probing lost, renaming",
+					zap.String("name", name), zap.String("new name", newName))
+				retargeted, oldPTRs := c.config.renameName(name, newName)
+				for _, target := range retargeted {
+					renamedTargets = appendName(renamedTargets, target)
+				}
+				// Peers must forget the PTR records to the old name
+				if len(oldPTRs) > 0 {
+					c.goodbye(func(net.IP) []dns.RR {
+						records := make([]dns.RR, 0, len(oldPTRs))
+						for _, rr := range oldPTRs {
+							records = append(records, dns.Copy(rr))
+						}
+						return records
+					})
+				}
+				name = newName
+				continue
+			}
+			c.config.removeName(name)
+			Log().Debug("Probing lost, name in use", zap.String("name", name))
+		} else if err != nil {
+			return err
+		} else {
+			c.config.establishName(name)
+			Log().Debug("Probing succeeded", zap.String("name", name))
+			go c.announce(name)
+			// SRV and PTR records pointing at the old name have new data
+			for _, target := range renamedTargets {
+				go c.announce(target)
+			}
+			if name != original && c.config.RenameCallback != nil {
+				c.config.RenameCallback(original, name)
+			}
+		}
+
+		if c.config.ProbeCallback != nil {
+			c.config.ProbeCallback(name, err)
+		}
+		return err
+	}
+}
+
+// noteConflict records a lost probe for the probe rate limit
+func (c *Conn) noteConflict() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	recent := c.conflicts[:0]
+	for _, t := range c.conflicts {
+		if now.Sub(t) < conflictWindow {
+			recent = append(recent, t)
+		}
+	}
+	c.conflicts = append(recent, now)
+}
+
+// probeDelay returns how long to wait before the first probe, "If fifteen
+// conflicts occur within any ten-second period, then the host MUST wait at
+// least five seconds before each successive additional probe attempt."
+func (c *Conn) probeDelay() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	recent := 0
+	for _, t := range c.conflicts {
+		if time.Since(t) < conflictWindow {
+			recent++
+		}
+	}
+	if recent >= maxConflicts {
+		return conflictProbeDelay
+	}
+	return time.Duration(rand.Int63n(int64(probeInterval)))
+}
+
+// probeTentative probes all the names added before the server started
+func (c *Conn) probeTentative() {
+	for _, name := range c.config.tentativeNames() {
+		go c.probeName(name) //nolint errcheck
+	}
+}
+
+// probe implements RFC 6762 section 8.1, three probes 250ms apart are sent
+// with our records in the authority section, if nobody answers the name is
+// ours. Losing a simultaneous probe tie-break delays the probe for one second.
+func (c *Conn) probe(name string) error {
+	p := &prober{
+		name:     name,
+		lost:     make(chan struct{}, 1),
+		deferred: make(chan struct{}, 1),
+	}
+	key := strings.ToLower(name)
+
+	c.lock.Lock()
+	c.probes[key] = p
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.probes, key)
+		c.lock.Unlock()
+	}()
+
+	// "When the host is ready to send his probe packet(s) ... it should
+	// first wait for a short random delay time, uniformly distributed in
+	// the range 0-250 ms."
+	delay := c.probeDelay()
+
+	for {
+		select {
+		case <-time.After(delay):
+		case <-p.lost:
+			return ErrNameConflict
+		case <-c.closed:
+			return errConnectionClosed
+		}
+
+		restart := false
+		for i := 0; i < probeCount && !restart; i++ {
+			c.sendProbe(name)
+			select {
+			case <-time.After(probeInterval):
+			case <-p.lost:
+				return ErrNameConflict
+			case <-p.deferred:
+				restart = true
+			case <-c.closed:
+				return errConnectionClosed
+			}
+		}
+		if !restart {
+			return nil
+		}
+		Log().Debug("Lost simultaneous probe tie-break, probing again", zap.String("name", name))
+		delay = probeDeferDelay
+	}
+}
+
+// sendProbe sends a probe for name on every interface
+func (c *Conn) sendProbe(name string) {
+	for i := range c.ifaces {
+		records := c.config.recordsFor(name, interfaceIPv4(&c.ifaces[i]))
+		if len(records) == 0 {
+			continue
+		}
+
+		msg := new(dns.Msg)
+		msg.Id = 0
+		msg.Question = []dns.Question{{
+			Name:   name,
+			Qtype:  dns.TypeANY,
+			Qclass: dns.ClassINET | qclassUnicastResponse,
+		}}
+		msg.Ns = records
+
+		rawProbe, err := msg.Pack()
+		if err != nil {
+			Log().Debug("Failed to construct mDNS probe", zap.Error(err))
+			continue
+		}
+		if err := c.writeTo(rawProbe, c.ifaces[i].Index, c.dstAddr); err != nil {
+			Log().Debug("Failed to send mDNS probe", zap.Error(err))
+		}
+	}
+}
+
+// activeProbe returns the probe running for a name or nil
+func (c *Conn) activeProbe(name string) *prober {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.probes[strings.ToLower(name)]
+}
+
+// processProbes does the simultaneous probe tie-break of RFC 6762
+// section 8.2 for queries carrying records in the authority section
+func (c *Conn) processProbes(msg dns.Msg, p packet) {
+	if len(msg.Ns) == 0 {
+		return
+	}
+
+	for _, q := range msg.Question {
+		pr := c.activeProbe(q.Name)
+		if pr == nil {
+			continue
+		}
+
+		theirs := make([]dns.RR, 0)
+		for _, rr := range msg.Ns {
+			if strings.EqualFold(rr.Header().Name, q.Name) {
+				theirs = append(theirs, rr)
+			}
+		}
+		if c.isOwnRecordSet(q.Name, theirs) {
+			continue
+		}
+
+		ours := c.config.recordsFor(q.Name, c.localIP(p.ifIndex, p.src))
+		if compareRecordSets(ours, theirs) < 0 {
+			signal(pr.deferred)
+		}
+	}
+}
+
+// checkProbeConflicts signals the probes that lost to a response,
+// any record for the name being probed that is not ours is a conflict
+func (c *Conn) checkProbeConflicts(msg dns.Msg, p packet) {
+	records := append(append([]dns.RR{}, msg.Answer...), msg.Extra...)
+	for _, rr := range records {
+		// Goodbye packets do not claim the name, the host is giving it up
+		if rr.Header().Ttl == 0 {
+			continue
+		}
+		pr := c.activeProbe(rr.Header().Name)
+		if pr == nil {
+			continue
+		}
+
+		if !c.isOwnRecord(rr) {
+			Log().Debug("Conflicting response while probing",
+				zap.String("name", pr.name), zap.String("source", p.src.String()))
+			signal(pr.lost)
+		}
+	}
+}
+
+// isOwnRecordSet returns true if records are the ones we send for name
+// on any of our interfaces, which is our own probe looped back
+func (c *Conn) isOwnRecordSet(name string, records []dns.RR) bool {
+	for i := range c.ifaces {
+		ours := c.config.recordsFor(name, interfaceIPv4(&c.ifaces[i]))
+		if compareRecordSets(ours, records) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnRecord returns true if rr is one of the records we send on any of
+// our interfaces
+func (c *Conn) isOwnRecord(rr dns.RR) bool {
+	for i := range c.ifaces {
+		if containsRecord(c.config.recordsFor(rr.Header().Name, interfaceIPv4(&c.ifaces[i])), rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsRecord returns true if records has a record with the same
+// name, type, class and rdata as rr, ignoring the TTL and cache-flush bit
+func containsRecord(records []dns.RR, rr dns.RR) bool {
+	for _, r := range records {
+		if sameRecord(r, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRecord compares two records ignoring the TTL and cache-flush bit
+func sameRecord(a, b dns.RR) bool {
+	if !strings.EqualFold(a.Header().Name, b.Header().Name) {
+		return false
+	}
+	return compareRecords(a, b) == 0
+}
+
+// compareRecords orders records by class, type and raw rdata as
+// described in RFC 6762 section 8.2
+func compareRecords(a, b dns.RR) int {
+	ac, bc := a.Header().Class&^rrclassCacheFlush, b.Header().Class&^rrclassCacheFlush
+	switch {
+	case ac < bc:
+		return -1
+	case ac > bc:
+		return 1
+	}
+
+	at, bt := a.Header().Rrtype, b.Header().Rrtype
+	switch {
+	case at < bt:
+		return -1
+	case at > bt:
+		return 1
+	}
+
+	return bytes.Compare(rdata(a), rdata(b))
+}
+
+// compareRecordSets compares our records against a set of records from
+// a simultaneous probe, returns a negative number if ours are
+// lexicographically earlier and we lose the tie-break
+func compareRecordSets(ours, theirs []dns.RR) int {
+	ours = sortedRecords(ours)
+	theirs = sortedRecords(theirs)
+
+	for i := 0; i < len(ours) && i < len(theirs); i++ {
+		if cmp := compareRecords(ours[i], theirs[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(ours) - len(theirs)
+}
+
+func sortedRecords(records []dns.RR) []dns.RR {
+	sorted := append([]dns.RR{}, records...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareRecords(sorted[i], sorted[j]) < 0
+	})
+	return sorted
+}
+
+// rdata returns the uncompressed wire format of the record data
+func rdata(rr dns.RR) []byte {
+	rr = dns.Copy(rr)
+	buf := make([]byte, dns.Len(rr))
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	return buf[off-int(rr.Header().Rdlength) : off]
+}
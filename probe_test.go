@@ -0,0 +1,109 @@
+package mdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("dns.NewRR(%q): %v", s, err)
+	}
+	return rr
+}
+
+func mustRRs(t *testing.T, ss ...string) []dns.RR {
+	t.Helper()
+	rrs := make([]dns.RR, 0, len(ss))
+	for _, s := range ss {
+		rrs = append(rrs, mustRR(t, s))
+	}
+	return rrs
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestCompareRecordSets(t *testing.T) {
+	tests := []struct {
+		name   string
+		ours   []string
+		theirs []string
+		want   int
+	}{
+		{
+			name:   "identical",
+			ours:   []string{"host.local. 120 IN A 169.254.99.200"},
+			theirs: []string{"host.local. 120 IN A 169.254.99.200"},
+			want:   0,
+		},
+		{
+			// RFC 6762 section 8.2 example, 169.254.200.50 wins over 169.254.99.200
+			name:   "lower rdata loses",
+			ours:   []string{"host.local. 120 IN A 169.254.99.200"},
+			theirs: []string{"host.local. 120 IN A 169.254.200.50"},
+			want:   -1,
+		},
+		{
+			name:   "higher rdata wins",
+			ours:   []string{"host.local. 120 IN A 169.254.200.50"},
+			theirs: []string{"host.local. 120 IN A 169.254.99.200"},
+			want:   1,
+		},
+		{
+			name:   "type compared before rdata",
+			ours:   []string{"host.local. 120 IN A 255.255.255.255"},
+			theirs: []string{`host.local. 120 IN TXT "a"`},
+			want:   -1,
+		},
+		{
+			name:   "cache-flush bit ignored",
+			ours:   []string{"host.local. 120 CLASS32769 A 10.0.0.1"},
+			theirs: []string{"host.local. 120 IN A 10.0.0.1"},
+			want:   0,
+		},
+		{
+			name:   "order within the set ignored",
+			ours:   []string{"host.local. 120 IN A 10.0.0.1", "host.local. 120 IN A 10.0.0.2"},
+			theirs: []string{"host.local. 120 IN A 10.0.0.2", "host.local. 120 IN A 10.0.0.1"},
+			want:   0,
+		},
+		{
+			name:   "more records win when the rest is equal",
+			ours:   []string{"host.local. 120 IN A 10.0.0.1", "host.local. 120 IN A 10.0.0.2"},
+			theirs: []string{"host.local. 120 IN A 10.0.0.1"},
+			want:   1,
+		},
+		{
+			name:   "fewer records lose when the rest is equal",
+			ours:   []string{"host.local. 120 IN A 10.0.0.1"},
+			theirs: []string{"host.local. 120 IN A 10.0.0.1", "host.local. 120 IN A 10.0.0.2"},
+			want:   -1,
+		},
+		{
+			name:   "first difference decides",
+			ours:   []string{"host.local. 120 IN A 10.0.0.1", "host.local. 120 IN A 10.0.0.9"},
+			theirs: []string{"host.local. 120 IN A 10.0.0.2", "host.local. 120 IN A 10.0.0.3"},
+			want:   -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compareRecordSets(mustRRs(t, tt.ours...), mustRRs(t, tt.theirs...))
+			if sign(got) != tt.want {
+				t.Errorf("compareRecordSets() = %d, want sign %d", got, tt.want)
+			}
+		})
+	}
+}
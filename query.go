@@ -0,0 +1,247 @@
+package mdns
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	queryDelayMin           = 20 * time.Millisecond
+	queryDelayMax           = 120 * time.Millisecond
+	defaultMaxQueryInterval = 60 * time.Minute
+
+	// watchBuffer is how many results a continuous query holds for a
+	// slow reader before dropping them
+	watchBuffer = 16
+)
+
+// querySchedule sends the questions for a name and type, RFC 6762
+// section 5.2, all the pending queries for them share it. waiters are
+// answered once, watchers get every answer until they are removed
+type querySchedule struct {
+	name     string
+	ttype    uint16
+	waiters  []chan QueryResult
+	watchers []chan QueryResult
+	// interval is how long to wait after the next question
+	interval time.Duration
+	// expires is when the answers last received expire
+	expires time.Time
+	// stop ends the goroutine sending the questions, nil if none runs
+	stop chan struct{}
+	// seen is when another host last sent the same question
+	seen time.Time
+}
+
+func queryKey(name string, ttype uint16) string {
+	return fmt.Sprintf("%s/%d", strings.ToLower(name), ttype)
+}
+
+// idle returns true if nobody waits on the schedule
+func (s *querySchedule) idle() bool {
+	return len(s.waiters) == 0 && len(s.watchers) == 0
+}
+
+// addQuery registers a pending query and returns the channel its result
+// is sent on, questions are sent until it is answered or removed. A
+// continuous query is not done when answered, it gets every answer until
+// it is removed
+func (c *Conn) addQuery(name string, ttype uint16, continuous bool) chan QueryResult {
+	results := make(chan QueryResult, 1)
+	if continuous {
+		results = make(chan QueryResult, watchBuffer)
+	}
+	key := queryKey(name, ttype)
+
+	c.queryLock.Lock()
+	defer c.queryLock.Unlock()
+
+	c.pruneQueries()
+	s, ok := c.queries[key]
+	if !ok {
+		s = &querySchedule{name: name, ttype: ttype}
+		c.queries[key] = s
+	}
+	if continuous {
+		s.watchers = append(s.watchers, results)
+	} else {
+		s.waiters = append(s.waiters, results)
+	}
+	if s.stop == nil {
+		s.stop = make(chan struct{})
+		go c.runQuery(s, s.stop)
+	}
+	return results
+}
+
+// removeQuery removes a pending query, the questions stop when no query
+// for the name and type is left
+func (c *Conn) removeQuery(name string, ttype uint16, results chan QueryResult) {
+	c.queryLock.Lock()
+	defer c.queryLock.Unlock()
+
+	s, ok := c.queries[queryKey(name, ttype)]
+	if !ok {
+		return
+	}
+	s.waiters = removeChan(s.waiters, results)
+	s.watchers = removeChan(s.watchers, results)
+	if s.idle() && s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// answerQuery sends result to the pending queries for name and type,
+// ttl is how long the answers are valid
+func (c *Conn) answerQuery(name string, ttype uint16, result QueryResult, ttl uint32) {
+	c.queryLock.Lock()
+	defer c.queryLock.Unlock()
+
+	s, ok := c.queries[queryKey(name, ttype)]
+	if !ok {
+		return
+	}
+	for _, ch := range s.waiters {
+		ch <- result
+	}
+	s.waiters = nil
+	for _, ch := range s.watchers {
+		select {
+		case ch <- result:
+		default:
+			Log().Debug("Dropping result of continuous query", zap.String("name", s.name))
+		}
+	}
+	s.expires = time.Now().Add(time.Duration(ttl) * time.Second)
+	if s.idle() && s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// removeChan returns chans without ch
+func removeChan(chans []chan QueryResult, ch chan QueryResult) []chan QueryResult {
+	for i := range chans {
+		if chans[i] == ch {
+			return append(chans[:i], chans[i+1:]...)
+		}
+	}
+	return chans
+}
+
+// pruneQueries forgets the schedules nobody waits on whose answers
+// expired, must be called with the query lock held
+func (c *Conn) pruneQueries() {
+	now := time.Now()
+	for key, s := range c.queries {
+		if s.stop == nil && !now.Before(s.expires) {
+			delete(c.queries, key)
+		}
+	}
+}
+
+// runQuery sends the questions of a schedule until stop is closed, the
+// first after a random delay of 20-120ms and the next ones with the
+// interval doubling each time up to the maximum, "the interval between
+// the first two queries MUST be at least one second, the intervals between
+// successive queries MUST increase by at least a factor of two". The
+// backoff starts over when the answers we had expire.
+func (c *Conn) runQuery(s *querySchedule, stop chan struct{}) {
+	delay := firstQueryDelay()
+	for {
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		case <-c.closed:
+			return
+		}
+
+		c.queryLock.Lock()
+		now := time.Now()
+		// Another host asked the same question while we waited
+		suppressed := s.seen.After(now.Add(-delay))
+		delay = s.nextInterval(now, c.queryInterval, c.maxQueryInterval)
+		c.queryLock.Unlock()
+
+		if suppressed {
+			atomic.AddUint64(&c.stats.QueriesSuppressed, 1)
+			Log().Debug("Question already asked by another host", zap.String("name", s.name))
+			continue
+		}
+		c.sendQuestion(s.name, s.ttype)
+	}
+}
+
+// firstQueryDelay returns the random delay of 20-120ms before the first
+// question, so hosts starting together do not all ask at once
+func firstQueryDelay() time.Duration {
+	return queryDelayMin + time.Duration(rand.Int63n(int64(queryDelayMax-queryDelayMin)))
+}
+
+// nextInterval returns how long to wait after the question sent now and
+// doubles the interval for the next one up to max, it starts over from
+// first when the answers we had expired. Must be called with the query
+// lock held
+func (s *querySchedule) nextInterval(now time.Time, first, max time.Duration) time.Duration {
+	if s.interval == 0 || (!s.expires.IsZero() && now.After(s.expires)) {
+		s.interval = first
+		s.expires = time.Time{}
+	}
+	interval := s.interval
+	s.interval *= 2
+	if s.interval > max {
+		s.interval = max
+	}
+	return interval
+}
+
+// noteQuestions implements RFC 6762 section 7.3, "If a host is planning
+// to transmit (or retransmit) a query, and it sees another host on the
+// network send a query containing the same "QM" question, and the
+// Known-Answer Section of that query does not contain any records that
+// this host would not also put in its own Known-Answer Section, then this
+// host SHOULD treat its own query as having been sent."
+func (c *Conn) noteQuestions(msg dns.Msg, p packet) {
+	// The Known-Answer list of a truncated query is not complete yet, and
+	// our own questions come back to us too
+	if msg.Truncated || c.isOwnPacket(p) {
+		return
+	}
+
+	c.queryLock.Lock()
+	defer c.queryLock.Unlock()
+
+	for _, q := range msg.Question {
+		if q.Qclass&qclassUnicastResponse != 0 {
+			continue
+		}
+		s, ok := c.queries[queryKey(q.Name, q.Qtype)]
+		if !ok || s.stop == nil {
+			continue
+		}
+
+		ours := c.cache.knownAnswers(q.Name, q.Qtype)
+		subset := true
+		for _, rr := range msg.Answer {
+			h := rr.Header()
+			if !strings.EqualFold(h.Name, q.Name) || (q.Qtype != dns.TypeANY && h.Rrtype != q.Qtype) {
+				continue
+			}
+			if !containsRecord(ours, rr) {
+				subset = false
+				break
+			}
+		}
+		if subset {
+			s.seen = time.Now()
+		}
+	}
+}
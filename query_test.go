@@ -0,0 +1,154 @@
+package mdns
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestFirstQueryDelay(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if d := firstQueryDelay(); d < queryDelayMin || d >= queryDelayMax {
+			t.Fatalf("firstQueryDelay() = %v, want 20-120ms", d)
+		}
+	}
+}
+
+func TestQueryScheduleNextInterval(t *testing.T) {
+	start := time.Now()
+	type step struct {
+		// at is when the question is sent, relative to start
+		at time.Duration
+		// expires sets when the answers expire before the question, if not
+		// zero, relative to start
+		expires time.Duration
+		want    time.Duration
+	}
+	tests := []struct {
+		name  string
+		first time.Duration
+		max   time.Duration
+		steps []step
+	}{
+		{
+			name:  "doubling up to the maximum",
+			first: time.Second,
+			max:   8 * time.Second,
+			steps: []step{
+				{want: time.Second},
+				{want: 2 * time.Second},
+				{want: 4 * time.Second},
+				{want: 8 * time.Second},
+				{want: 8 * time.Second},
+				{want: 8 * time.Second},
+			},
+		},
+		{
+			name:  "default maximum",
+			first: defaultQueryInterval,
+			max:   defaultMaxQueryInterval,
+			steps: []step{
+				{want: time.Second}, {want: 2 * time.Second}, {want: 4 * time.Second},
+				{want: 8 * time.Second}, {want: 16 * time.Second}, {want: 32 * time.Second},
+				{want: 64 * time.Second}, {want: 128 * time.Second}, {want: 256 * time.Second},
+				{want: 512 * time.Second}, {want: 1024 * time.Second}, {want: 2048 * time.Second},
+				{want: 3600 * time.Second}, {want: 3600 * time.Second},
+			},
+		},
+		{
+			name:  "starts over when the answers expire",
+			first: time.Second,
+			max:   time.Hour,
+			steps: []step{
+				{at: 0, want: time.Second},
+				{at: time.Second, expires: 2 * time.Minute, want: 2 * time.Second},
+				{at: 3 * time.Second, want: 4 * time.Second},
+				{at: 3 * time.Minute, want: time.Second},
+				{at: 3*time.Minute + time.Second, want: 2 * time.Second},
+			},
+		},
+		{
+			name:  "answers not expired yet",
+			first: time.Second,
+			max:   time.Hour,
+			steps: []step{
+				{at: 0, expires: time.Minute, want: time.Second},
+				{at: time.Second, want: 2 * time.Second},
+				{at: 3 * time.Second, want: 4 * time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &querySchedule{}
+			for i, st := range tt.steps {
+				if st.expires != 0 {
+					s.expires = start.Add(st.expires)
+				}
+				if got := s.nextInterval(start.Add(st.at), tt.first, tt.max); got != st.want {
+					t.Errorf("step %d: nextInterval() = %v, want %v", i, got, st.want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryScheduleShared(t *testing.T) {
+	c := &Conn{
+		queries:          make(map[string]*querySchedule),
+		queryInterval:    defaultQueryInterval,
+		maxQueryInterval: defaultMaxQueryInterval,
+		closed:           make(chan interface{}),
+	}
+	// Nothing is sent once the connection is closed
+	close(c.closed)
+
+	first := c.addQuery("catalog.local.", dns.TypeA, false)
+	second := c.addQuery("Catalog.local.", dns.TypeA, false)
+	watcher := c.addQuery("catalog.local.", dns.TypeA, true)
+	other := c.addQuery("catalog.local.", dns.TypeSRV, false)
+
+	c.queryLock.Lock()
+	if len(c.queries) != 2 {
+		t.Errorf("got %d schedules, want one per name and type", len(c.queries))
+	}
+	s := c.queries[queryKey("catalog.local.", dns.TypeA)]
+	if len(s.waiters) != 2 || len(s.watchers) != 1 {
+		t.Errorf("got %d waiters and %d watchers, want 2 and 1", len(s.waiters), len(s.watchers))
+	}
+	c.queryLock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ch := range []chan QueryResult{first, second, watcher} {
+		wg.Add(1)
+		go func(ch chan QueryResult) {
+			defer wg.Done()
+			select {
+			case <-ch:
+			case <-time.After(time.Second):
+				t.Errorf("no result")
+			}
+		}(ch)
+	}
+	c.answerQuery("CATALOG.local.", dns.TypeA, QueryResult{}, 120)
+	wg.Wait()
+
+	c.queryLock.Lock()
+	if len(s.waiters) != 0 || s.stop == nil {
+		t.Errorf("want the waiters answered and the watcher still querying")
+	}
+	c.queryLock.Unlock()
+
+	c.removeQuery("catalog.local.", dns.TypeA, watcher)
+	c.removeQuery("catalog.local.", dns.TypeSRV, other)
+	c.queryLock.Lock()
+	defer c.queryLock.Unlock()
+	for key, s := range c.queries {
+		if s.stop != nil {
+			t.Errorf("%s: still querying with nobody waiting", key)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package mdns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// multicastInterval is how often a record may be multicast on an
+	// interface, probeDefenseInterval applies when answering probes
+	multicastInterval    = 1 * time.Second
+	probeDefenseInterval = 250 * time.Millisecond
+
+	// multicastHistory is how long we remember sending a record
+	multicastHistory = 1 * time.Hour
+	pruneInterval    = 1 * time.Minute
+)
+
+// multicastTracker remembers when each record was last multicast on each
+// interface, RFC 6762 section 6
+type multicastTracker struct {
+	sync.Mutex
+	lastSent  map[string]time.Time
+	lastPrune time.Time
+}
+
+func newMulticastTracker() *multicastTracker {
+	return &multicastTracker{
+		lastSent:  make(map[string]time.Time),
+		lastPrune: time.Now(),
+	}
+}
+
+// lastMulticast returns when rr was last multicast on an interface, the
+// zero time if never
+func (m *multicastTracker) lastMulticast(ifIndex int, rr dns.RR) time.Time {
+	m.Lock()
+	defer m.Unlock()
+	return m.lastSent[trackerKey(ifIndex, rr)]
+}
+
+// sent records that rr was multicast on an interface
+func (m *multicastTracker) sent(ifIndex int, rr dns.RR) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	m.lastSent[trackerKey(ifIndex, rr)] = now
+
+	if now.Sub(m.lastPrune) < pruneInterval {
+		return
+	}
+	for key, t := range m.lastSent {
+		if now.Sub(t) > multicastHistory {
+			delete(m.lastSent, key)
+		}
+	}
+	m.lastPrune = now
+}
+
+// rateLimit removes the records multicast on an interface less than
+// interval ago, "a Multicast DNS responder MUST NOT (except in the one
+// special case of answering probe queries) multicast a record on a given
+// interface until at least one second has elapsed since the last time
+// that record was multicast on that particular interface."
+func (c *Conn) rateLimit(records []dns.RR, ifIndex int, interval time.Duration) []dns.RR {
+	allowed := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		if time.Since(c.multicasts.lastMulticast(ifIndex, rr)) < interval {
+			atomic.AddUint64(&c.stats.RateLimited, 1)
+			continue
+		}
+		allowed = append(allowed, rr)
+	}
+	return allowed
+}
+
+// recordKey identifies a record by name, type, class and rdata
+func recordKey(rr dns.RR) string {
+	h := rr.Header()
+	return fmt.Sprintf("%s/%d/%d/%s", strings.ToLower(h.Name), h.Rrtype,
+		h.Class&^rrclassCacheFlush, hex.EncodeToString(rdata(rr)))
+}
+
+func trackerKey(ifIndex int, rr dns.RR) string {
+	return fmt.Sprintf("%d/%s", ifIndex, recordKey(rr))
+}
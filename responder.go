@@ -0,0 +1,306 @@
+package mdns
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	sharedDelayMin = 20 * time.Millisecond
+	sharedDelayMax = 120 * time.Millisecond
+
+	// ipUDPHeaderSize is the size of the IPv4 and UDP headers of a packet
+	ipUDPHeaderSize = 20 + 8
+	// defaultMTU is used when the interface MTU is unknown
+	defaultMTU = 1500
+	// maxPacketSize is the largest mDNS packet including the IP and UDP
+	// headers, RFC 6762 section 17
+	maxPacketSize = 9000
+)
+
+// pendingResponse holds the answers waiting to be multicast on an
+// interface, they are sent together as a single message
+type pendingResponse struct {
+	answers []dns.RR
+	extra   []dns.RR
+	due     time.Time
+	timer   *time.Timer
+}
+
+// responder schedules multicast responses per interface so answers due
+// close together are aggregated, RFC 6762 section 6
+type responder struct {
+	sync.Mutex
+	pending map[int]*pendingResponse
+}
+
+func newResponder() *responder {
+	return &responder{
+		pending: make(map[int]*pendingResponse),
+	}
+}
+
+// isShared returns true for records other responders may answer with too
+func isShared(rr dns.RR) bool {
+	return rr.Header().Rrtype == dns.TypePTR
+}
+
+// responseDelay returns how long to wait before multicasting answers,
+// "In any case where there may be multiple responses, such as queries
+// where the answer is a member of a shared resource record set, each
+// responder SHOULD delay its response by a random amount of time selected
+// with uniform random distribution in the range 20-120 ms."
+func responseDelay(answers []dns.RR) time.Duration {
+	for _, rr := range answers {
+		if isShared(rr) {
+			return sharedDelayMin + time.Duration(rand.Int63n(int64(sharedDelayMax-sharedDelayMin)))
+		}
+	}
+	return 0
+}
+
+// suppressKnownAnswers removes the answers listed in the Known-Answer
+// section of a query, RFC 6762 section 7.1 "A Multicast DNS responder MUST
+// NOT answer a Multicast DNS query if the answer it would give is already
+// included in the Answer Section with an RR TTL at least half the correct
+// value."
+func (c *Conn) suppressKnownAnswers(answers, known []dns.RR) []dns.RR {
+	if len(known) == 0 {
+		return answers
+	}
+
+	needed := make([]dns.RR, 0, len(answers))
+	for _, rr := range answers {
+		if isKnownAnswer(rr, known) {
+			atomic.AddUint64(&c.stats.KnownAnswers, 1)
+			continue
+		}
+		needed = append(needed, rr)
+	}
+	return needed
+}
+
+// isKnownAnswer returns true if known has rr with at least half its TTL
+func isKnownAnswer(rr dns.RR, known []dns.RR) bool {
+	for _, k := range known {
+		if sameRecord(rr, k) && k.Header().Ttl >= rr.Header().Ttl/2 {
+			return true
+		}
+	}
+	return false
+}
+
+// splitUnicast splits the answers to a QU question into the ones we can
+// unicast and the ones to multicast, "If the responder has not multicast
+// that record recently (within one quarter of its TTL), then the responder
+// SHOULD instead multicast the response so as to keep all the peer caches
+// up to date"
+func (c *Conn) splitUnicast(answers []dns.RR, ifIndex int) ([]dns.RR, []dns.RR) {
+	unicast := make([]dns.RR, 0, len(answers))
+	multicast := make([]dns.RR, 0, len(answers))
+	for _, rr := range answers {
+		quarterTTL := time.Duration(rr.Header().Ttl) * time.Second / 4
+		if time.Since(c.multicasts.lastMulticast(ifIndex, rr)) < quarterTTL {
+			unicast = append(unicast, rr)
+		} else {
+			multicast = append(multicast, rr)
+		}
+	}
+	return unicast, multicast
+}
+
+// sendUnicast sends a response straight back to the querier of packet p
+func (c *Conn) sendUnicast(msg *dns.Msg, p packet) {
+	for _, m := range splitResponse(msg, c.messageSize(p.ifIndex)) {
+		rawAnswer, err := m.Pack()
+		if err != nil {
+			Log().Debug("Failed to construct mDNS packet", zap.Error(err))
+			continue
+		}
+		if err := c.writeTo(rawAnswer, p.ifIndex, p.src); err != nil {
+			Log().Debug("Failed to send mDNS packet", zap.Error(err))
+		}
+	}
+}
+
+// messageSize returns the largest message that fits in one packet on an
+// interface, "the packet size ... MUST NOT exceed the MTU of the
+// interface" and never more than 9000 bytes in total
+func (c *Conn) messageSize(ifIndex int) int {
+	mtu := defaultMTU
+	if ifi := c.interfaceByIndex(ifIndex); ifi != nil && ifi.MTU > 0 {
+		mtu = ifi.MTU
+	}
+	if mtu > maxPacketSize {
+		mtu = maxPacketSize
+	}
+	return mtu - ipUDPHeaderSize
+}
+
+// queryMessageSize returns the largest query that fits the MTU of all our
+// interfaces, queries go out on whichever interface the system picks
+func (c *Conn) queryMessageSize() int {
+	size := 0
+	for i := range c.ifaces {
+		if s := c.messageSize(c.ifaces[i].Index); size == 0 || s < size {
+			size = s
+		}
+	}
+	if size == 0 {
+		return c.messageSize(0)
+	}
+	return size
+}
+
+// splitResponse splits a response larger than size bytes into several
+// responses, records are never split and answers come before additional
+// records. A single record larger than size is sent alone.
+func splitResponse(msg *dns.Msg, size int) []*dns.Msg {
+	if msg.Len() <= size {
+		return []*dns.Msg{msg}
+	}
+
+	msgs := make([]*dns.Msg, 0)
+	next := func() *dns.Msg {
+		m := msg.Copy()
+		m.Answer, m.Ns, m.Extra = nil, nil, nil
+		msgs = append(msgs, m)
+		return m
+	}
+
+	m := next()
+	for _, rr := range msg.Answer {
+		m.Answer = append(m.Answer, rr)
+		if len(m.Answer) > 1 && m.Len() > size {
+			m.Answer = m.Answer[:len(m.Answer)-1]
+			m = next()
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	for _, rr := range msg.Extra {
+		m.Extra = append(m.Extra, rr)
+		if len(m.Answer)+len(m.Extra) > 1 && m.Len() > size {
+			m.Extra = m.Extra[:len(m.Extra)-1]
+			m = next()
+			m.Extra = append(m.Extra, rr)
+		}
+	}
+	return msgs
+}
+
+// additionalRecords returns the Additional section for answers, the
+// records of extra not answered already plus the NSEC records of our names
+func (c *Conn) additionalRecords(answers, extra []dns.RR) []dns.RR {
+	additionals := make([]dns.RR, 0, len(extra))
+	for _, rr := range extra {
+		if !containsRecord(answers, rr) && !containsRecord(additionals, rr) {
+			additionals = append(additionals, rr)
+		}
+	}
+
+	records := append(append([]dns.RR{}, answers...), additionals...)
+	return append(additionals, c.config.additionalNSEC(records)...)
+}
+
+// scheduleResponse queues answers and their additional records to be
+// multicast on an interface after delay. Answers join the response already
+// pending on the interface, which is sent when the earliest of its answers
+// is due.
+func (c *Conn) scheduleResponse(ifIndex int, answers, extra []dns.RR, delay time.Duration) {
+	r := c.responder
+	r.Lock()
+	defer r.Unlock()
+
+	due := time.Now().Add(delay)
+	pr, ok := r.pending[ifIndex]
+	if !ok {
+		pr = &pendingResponse{due: due}
+		pr.timer = time.AfterFunc(delay, func() { c.flushResponse(ifIndex) })
+		r.pending[ifIndex] = pr
+	} else if due.Before(pr.due) {
+		pr.due = due
+		pr.timer.Reset(delay)
+	}
+
+	for _, rr := range answers {
+		if !containsRecord(pr.answers, rr) {
+			pr.answers = append(pr.answers, rr)
+		}
+	}
+	for _, rr := range extra {
+		if !containsRecord(pr.extra, rr) {
+			pr.extra = append(pr.extra, rr)
+		}
+	}
+}
+
+// suppressDuplicateAnswers implements RFC 6762 section 7.4, "If a host is
+// planning to send an answer, and it sees another host on the network send
+// a response message containing the same answer record, and the TTL in
+// that record is not less than the TTL this host would have given, then
+// this host SHOULD treat its own answer as having been sent, and not also
+// send its own response."
+func (c *Conn) suppressDuplicateAnswers(msg dns.Msg, p packet) {
+	r := c.responder
+	r.Lock()
+	defer r.Unlock()
+
+	pr, ok := r.pending[p.ifIndex]
+	if !ok {
+		return
+	}
+
+	seen := append(append([]dns.RR{}, msg.Answer...), msg.Extra...)
+	remaining := pr.answers[:0]
+	for _, rr := range pr.answers {
+		if isDuplicateAnswer(rr, seen) {
+			atomic.AddUint64(&c.stats.DuplicateAnswers, 1)
+			c.multicasts.sent(p.ifIndex, rr)
+			continue
+		}
+		remaining = append(remaining, rr)
+	}
+	pr.answers = remaining
+
+	if len(pr.answers) == 0 {
+		pr.timer.Stop()
+		delete(r.pending, p.ifIndex)
+	}
+}
+
+// isDuplicateAnswer returns true if seen has rr with at least its TTL
+func isDuplicateAnswer(rr dns.RR, seen []dns.RR) bool {
+	for _, s := range seen {
+		if sameRecord(rr, s) && s.Header().Ttl >= rr.Header().Ttl {
+			return true
+		}
+	}
+	return false
+}
+
+// flushResponse multicasts the response pending on an interface
+func (c *Conn) flushResponse(ifIndex int) {
+	r := c.responder
+	r.Lock()
+	pr, ok := r.pending[ifIndex]
+	delete(r.pending, ifIndex)
+	r.Unlock()
+
+	if !ok || len(pr.answers) == 0 {
+		return
+	}
+
+	// Records answered already do not need repeating
+	extra := make([]dns.RR, 0, len(pr.extra))
+	for _, rr := range pr.extra {
+		if !containsRecord(pr.answers, rr) {
+			extra = append(extra, rr)
+		}
+	}
+	c.sendAnswer(createAnswerMessage(&dns.Msg{}, &pr.answers, &extra), ifIndex)
+}
@@ -0,0 +1,151 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestIsKnownAnswer(t *testing.T) {
+	rr := "host.local. 120 CLASS32769 A 10.0.0.1"
+	tests := []struct {
+		name  string
+		known []string
+		want  bool
+	}{
+		{"empty list", nil, false},
+		{"full TTL", []string{"host.local. 120 IN A 10.0.0.1"}, true},
+		{"half TTL", []string{"host.local. 60 IN A 10.0.0.1"}, true},
+		{"less than half TTL", []string{"host.local. 59 IN A 10.0.0.1"}, false},
+		{"name case ignored", []string{"HOST.local. 120 IN A 10.0.0.1"}, true},
+		{"other rdata", []string{"host.local. 120 IN A 10.0.0.2"}, false},
+		{"other name", []string{"other.local. 120 IN A 10.0.0.1"}, false},
+		{"other type", []string{`host.local. 120 IN TXT "10.0.0.1"`}, false},
+		{"one of several", []string{"host.local. 120 IN A 10.0.0.2", "host.local. 100 IN A 10.0.0.1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isKnownAnswer(mustRR(t, rr), mustRRs(t, tt.known...)); got != tt.want {
+				t.Errorf("isKnownAnswer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDuplicateAnswer(t *testing.T) {
+	rr := "host.local. 120 CLASS32769 A 10.0.0.1"
+	tests := []struct {
+		name string
+		seen []string
+		want bool
+	}{
+		{"nothing seen", nil, false},
+		{"same TTL", []string{"host.local. 120 CLASS32769 A 10.0.0.1"}, true},
+		{"longer TTL", []string{"host.local. 4500 CLASS32769 A 10.0.0.1"}, true},
+		{"shorter TTL", []string{"host.local. 119 CLASS32769 A 10.0.0.1"}, false},
+		{"goodbye", []string{"host.local. 0 CLASS32769 A 10.0.0.1"}, false},
+		{"cache-flush bit ignored", []string{"host.local. 120 IN A 10.0.0.1"}, true},
+		{"name case ignored", []string{"Host.Local. 120 CLASS32769 A 10.0.0.1"}, true},
+		{"other rdata", []string{"host.local. 120 CLASS32769 A 10.0.0.2"}, false},
+		{"other name", []string{"other.local. 120 CLASS32769 A 10.0.0.1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDuplicateAnswer(mustRR(t, rr), mustRRs(t, tt.seen...)); got != tt.want {
+				t.Errorf("isDuplicateAnswer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		answers  int
+		extra    int
+		size     int
+		wantMsgs int
+	}{
+		{"fits", 3, 3, 1472, 1},
+		{"answers split", 200, 0, 1472, 4},
+		{"answers and additionals split", 100, 100, 1472, 4},
+		{"jumbo frame", 200, 100, 8972, 1},
+		{"record larger than size sent alone", 3, 0, 20, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := new(dns.Msg)
+			msg.Response = true
+			msg.Compress = true
+			msg.Answer = hostRecords(t, tt.answers, 120, "IN")
+			msg.Extra = hostRecords(t, tt.extra, 120, "CLASS32769")
+
+			msgs := splitResponse(msg, tt.size)
+
+			if len(msgs) != tt.wantMsgs {
+				t.Errorf("got %d messages, want %d", len(msgs), tt.wantMsgs)
+			}
+			var answers, extra []dns.RR
+			for i, m := range msgs {
+				if l := m.Len(); l > tt.size && len(m.Answer)+len(m.Extra) > 1 {
+					t.Errorf("message %d: Len() = %d, want at most %d", i, l, tt.size)
+				}
+				if !m.Response || m.Truncated {
+					t.Errorf("message %d: want a response without the TC bit", i)
+				}
+				answers = append(answers, m.Answer...)
+				extra = append(extra, m.Extra...)
+			}
+			if !sameRecords(answers, msg.Answer) {
+				t.Errorf("answers not kept whole and in order")
+			}
+			if !sameRecords(extra, msg.Extra) {
+				t.Errorf("additional records not kept whole and in order")
+			}
+		})
+	}
+}
+
+// sameRecords returns true if a and b hold the same records in order
+func sameRecords(a, b []dns.RR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !dns.IsDuplicate(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQueryMessageSize(t *testing.T) {
+	tests := []struct {
+		name string
+		mtus []int
+		want int
+	}{
+		{"no interfaces", nil, defaultMTU - ipUDPHeaderSize},
+		{"one interface", []int{1500}, 1472},
+		{"smallest MTU", []int{1500, 1400, 9000}, 1372},
+		{"jumbo frames", []int{9000, 9000}, 8972},
+		{"MTU above the mDNS limit", []int{65536}, maxPacketSize - ipUDPHeaderSize},
+		{"unknown MTU", []int{0}, defaultMTU - ipUDPHeaderSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Conn{}
+			for i, mtu := range tt.mtus {
+				c.ifaces = append(c.ifaces, net.Interface{Index: i + 1, MTU: mtu})
+			}
+			if got := c.queryMessageSize(); got != tt.want {
+				t.Errorf("queryMessageSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
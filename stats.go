@@ -0,0 +1,38 @@
+package mdns
+
+import "sync/atomic"
+
+// Statistics counts events of the mdns process, a snapshot is returned
+// by Conn.Statistics
+type Statistics struct {
+	// RateLimited is the number of records not multicast because they
+	// were multicast on the same interface too recently
+	RateLimited uint64
+	// KnownAnswers is the number of answers not sent because the query
+	// listed them as already known
+	KnownAnswers uint64
+	// DuplicateAnswers is the number of scheduled answers not sent
+	// because another responder multicast them first
+	DuplicateAnswers uint64
+	// OffLink is the number of packets dropped because they did not come
+	// from a directly connected subnet
+	OffLink uint64
+	// OversizeDrops is the number of packets dropped because they were
+	// larger than the 9000 bytes allowed for mDNS packets
+	OversizeDrops uint64
+	// QueriesSuppressed is the number of questions we did not send
+	// because another host had just asked the same question
+	QueriesSuppressed uint64
+}
+
+// Statistics returns a snapshot of the counters of the connection
+func (c *Conn) Statistics() Statistics {
+	return Statistics{
+		RateLimited:       atomic.LoadUint64(&c.stats.RateLimited),
+		KnownAnswers:      atomic.LoadUint64(&c.stats.KnownAnswers),
+		DuplicateAnswers:  atomic.LoadUint64(&c.stats.DuplicateAnswers),
+		OffLink:           atomic.LoadUint64(&c.stats.OffLink),
+		OversizeDrops:     atomic.LoadUint64(&c.stats.OversizeDrops),
+		QueriesSuppressed: atomic.LoadUint64(&c.stats.QueriesSuppressed),
+	}
+}
@@ -0,0 +1,71 @@
+package mdns
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+const (
+	truncatedDelayMin = 400 * time.Millisecond
+	truncatedDelayMax = 500 * time.Millisecond
+)
+
+// heldQuery is a truncated query waiting for the rest of its Known-Answer
+// list
+type heldQuery struct {
+	msg dns.Msg
+	p   packet
+}
+
+// holdQuery implements RFC 6762 section 7.2, "If the TC bit is set, it
+// means that additional Known-Answer records may be following shortly. A
+// responder SHOULD record this fact, and wait for those additional
+// Known-Answer records, before deciding whether to respond." A truncated
+// query is held for 400-500ms and the packets that follow from the same
+// source are merged into it. Returns true if the query was held or merged
+// and must not be answered now.
+func (c *Conn) holdQuery(msg dns.Msg, p packet) bool {
+	key := p.src.String()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if h, ok := c.held[key]; ok {
+		h.msg.Question = append(h.msg.Question, msg.Question...)
+		h.msg.Answer = append(h.msg.Answer, msg.Answer...)
+		h.msg.Ns = append(h.msg.Ns, msg.Ns...)
+		return true
+	}
+	if !msg.Truncated {
+		return false
+	}
+
+	Log().Debug("Holding truncated query", zap.String("source", key))
+	c.held[key] = &heldQuery{msg: msg, p: p}
+	delay := truncatedDelayMin + time.Duration(rand.Int63n(int64(truncatedDelayMax-truncatedDelayMin)))
+	time.AfterFunc(delay, func() { c.releaseQuery(key) })
+	return true
+}
+
+// releaseQuery answers a held query with all the known answers received
+func (c *Conn) releaseQuery(key string) {
+	c.lock.Lock()
+	h, ok := c.held[key]
+	delete(c.held, key)
+	c.lock.Unlock()
+
+	if !ok {
+		return
+	}
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+
+	c.processProbes(h.msg, h.p)
+	c.processQuestions(h.msg, h.p)
+}
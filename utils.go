@@ -3,6 +3,9 @@ package mdns
 import (
 	"math/big"
 	"net"
+	"strings"
+
+	"github.com/miekg/dns"
 )
 
 func ipToBytes(ip net.IP) (out [4]byte) {
@@ -33,9 +36,107 @@ func interfaceForRemote(remote string) (net.IP, error) {
 
 func addDot(name string) string {
 	x := len(name)
-	if name[:x-1] != "." {
+	if x == 0 || name[x-1:] != "." {
 		return name + "."
 	}
 	return name
 
 }
+
+// appendName appends name to names unless it is already there
+func appendName(names []string, name string) []string {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+// appendRecords appends the records of rrs not already in records
+func appendRecords(records []dns.RR, rrs ...dns.RR) []dns.RR {
+	for _, rr := range rrs {
+		if !containsRecord(records, rr) {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// withoutOPT returns records without the EDNS0 OPT pseudo record
+func withoutOPT(records []dns.RR) []dns.RR {
+	filtered := make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			filtered = append(filtered, rr)
+		}
+	}
+	return filtered
+}
+
+// appendType appends rrtype to types unless it is already there
+func appendType(types []uint16, rrtype uint16) []uint16 {
+	for _, t := range types {
+		if t == rrtype {
+			return types
+		}
+	}
+	return append(types, rrtype)
+}
+
+// interfaceContains returns true if ip is on one of the subnets of an
+// interface
+func interfaceContains(ifi *net.Interface, ip net.IP) bool {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceHasIP returns true if ip is one of the addresses of an
+// interface
+func interfaceHasIP(ifi *net.Interface, ip net.IP) bool {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// interfaceIPv4 returns the first IPv4 address of an interface, or nil
+func interfaceIPv4(ifi *net.Interface) net.IP {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip := ipNet.IP.To4(); ip != nil {
+				return ip
+			}
+		}
+	}
+	return nil
+}
+
+// maxTTL returns the longest TTL of rrs
+func maxTTL(rrs []dns.RR) uint32 {
+	var ttl uint32
+	for _, rr := range rrs {
+		if rr.Header().Ttl > ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return ttl
+}